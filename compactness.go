@@ -0,0 +1,329 @@
+package main
+
+/*
+   Shape of every cluster, for judging regions by more than SSE.
+
+   Usage: compactness [-hulls file] filename
+
+   filename is km1 output, or anything else in km1's format. Clusters
+   without an "x y cN" centroid line get the mean of their points, and
+   noise points, labeled -1, get skipped.
+
+   For every cluster, prints a table row with:
+   points      - how many points
+   area        - area of the convex hull of its points
+   perimeter   - perimeter of the convex hull
+   polsby      - Polsby-Popper score, 4*pi*area/perimeter^2, the hull's
+                 area relative to a circle with the same perimeter
+   reock       - Reock score, the hull's area over the area of the
+                 smallest circle enclosing the cluster
+   maxradius   - farthest point from the centroid
+   meanradius  - mean distance of points from the centroid
+   diameter    - farthest apart pair of points
+   Both scores run from 0 to 1, 1 for a circle. Clusters with fewer than
+   three points, or all in a line, have no area and score 0.
+
+   -hulls writes each hull as "x y label" lines, the first vertex
+   repeated at the end to close it, with a blank line between clusters,
+   so gnuplot can draw them "with lines".
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+// Circle by center and radius
+type Circle struct {
+	center Point
+	r      float64
+}
+
+func main() {
+	hullsFile := flag.String("hulls", "", "write hull polygons to this file")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: compactness [-hulls file] filename")
+	}
+
+	clusters, centroids := readClusters(flag.Arg(0))
+
+	var hulls [][]Point
+
+	fmt.Printf("%7s %6s %12s %10s %6s %6s %10s %10s %10s\n",
+		"cluster", "points", "area", "perimeter", "polsby", "reock", "maxradius", "meanradius", "diameter")
+	for c, cluster := range clusters {
+		if len(cluster) == 0 {
+			hulls = append(hulls, nil)
+			continue
+		}
+		hull := convexHull(cluster)
+		hulls = append(hulls, hull)
+
+		area, perimeter := polygonArea(hull), polygonPerimeter(hull)
+		polsby := 0.0
+		if perimeter > 0 {
+			polsby = 4 * math.Pi * area / (perimeter * perimeter)
+		}
+		reock := 0.0
+		if circle := enclosingCircle(hull); circle.r > 0 {
+			reock = area / (math.Pi * circle.r * circle.r)
+		}
+
+		maxRadius, sumRadius := 0.0, 0.0
+		for _, p := range cluster {
+			r := distance(p, centroids[c])
+			maxRadius = math.Max(maxRadius, r)
+			sumRadius += r
+		}
+
+		fmt.Printf("%7d %6d %12.2f %10.2f %6.3f %6.3f %10.2f %10.2f %10.2f\n",
+			c, len(cluster), area, perimeter, polsby, reock,
+			maxRadius, sumRadius/float64(len(cluster)), diameter(hull))
+	}
+
+	if *hullsFile != "" {
+		fout, err := os.Create(*hullsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := bufio.NewWriter(fout)
+		for c, hull := range hulls {
+			if len(hull) == 0 {
+				continue
+			}
+			for _, p := range append(hull, hull[0]) {
+				fmt.Fprintf(w, "%f %f %d\n", p.x, p.y, c)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		if err := fout.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Convex hull by Andrew's monotone chain, counterclockwise.
+func convexHull(points []Point) []Point {
+	ps := append([]Point(nil), points...)
+	sort.Slice(ps, func(a, b int) bool {
+		if ps[a].x != ps[b].x {
+			return ps[a].x < ps[b].x
+		}
+		return ps[a].y < ps[b].y
+	})
+	if len(ps) < 3 {
+		return ps
+	}
+
+	var hull []Point
+	for _, p := range ps {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(ps) - 2; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], ps[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, ps[i])
+	}
+	return hull[:len(hull)-1]
+}
+
+func cross(o, a, b Point) float64 {
+	return (a.x-o.x)*(b.y-o.y) - (a.y-o.y)*(b.x-o.x)
+}
+
+// Shoelace formula.
+func polygonArea(polygon []Point) float64 {
+	if len(polygon) < 3 {
+		return 0
+	}
+	area := 0.0
+	for i := range polygon {
+		a, b := polygon[i], polygon[(i+1)%len(polygon)]
+		area += a.x*b.y - b.x*a.y
+	}
+	return math.Abs(area) / 2
+}
+
+func polygonPerimeter(polygon []Point) float64 {
+	if len(polygon) < 2 {
+		return 0
+	}
+	perimeter := 0.0
+	for i := range polygon {
+		perimeter += distance(polygon[i], polygon[(i+1)%len(polygon)])
+	}
+	return perimeter
+}
+
+// Farthest pair of hull vertices. Hulls are small, so try every pair.
+func diameter(hull []Point) float64 {
+	max := 0.0
+	for i := range hull {
+		for j := i + 1; j < len(hull); j++ {
+			max = math.Max(max, distance(hull[i], hull[j]))
+		}
+	}
+	return max
+}
+
+/*
+Smallest circle enclosing the points, by Welzl's algorithm in its
+iterative form: shuffle, then grow the circle every time a point falls
+outside it, with that point on the boundary. Expected linear time.
+The smallest circle around the hull is the smallest around the cluster.
+*/
+func enclosingCircle(points []Point) Circle {
+	ps := append([]Point(nil), points...)
+	rand.Shuffle(len(ps), func(i, j int) { ps[i], ps[j] = ps[j], ps[i] })
+
+	var c Circle
+	for i, p := range ps {
+		if i > 0 && inside(c, p) {
+			continue
+		}
+		c = Circle{center: p}
+		for j := 0; j < i; j++ {
+			if inside(c, ps[j]) {
+				continue
+			}
+			c = circleFrom2(p, ps[j])
+			for m := 0; m < j; m++ {
+				if !inside(c, ps[m]) {
+					c = circleFrom3(p, ps[j], ps[m])
+				}
+			}
+		}
+	}
+	return c
+}
+
+func inside(c Circle, p Point) bool {
+	return distance(c.center, p) <= c.r*(1+1e-12)+1e-12
+}
+
+func circleFrom2(a, b Point) Circle {
+	center := Point{x: (a.x + b.x) / 2, y: (a.y + b.y) / 2}
+	return Circle{center: center, r: distance(a, b) / 2}
+}
+
+// Circumcircle of a triangle, or the circle on the
+// farthest pair when the three points are in a line.
+func circleFrom3(a, b, c Point) Circle {
+	bx, by := b.x-a.x, b.y-a.y
+	cx, cy := c.x-a.x, c.y-a.y
+	d := 2 * (bx*cy - by*cx)
+	if d == 0 {
+		best := circleFrom2(a, b)
+		for _, circle := range []Circle{circleFrom2(a, c), circleFrom2(b, c)} {
+			if circle.r > best.r {
+				best = circle
+			}
+		}
+		return best
+	}
+	ux := (cy*(bx*bx+by*by) - by*(cx*cx+cy*cy)) / d
+	uy := (bx*(cx*cx+cy*cy) - cx*(bx*bx+by*by)) / d
+	center := Point{x: a.x + ux, y: a.y + uy}
+	return Circle{center: center, r: distance(center, a)}
+}
+
+func distance(a, b Point) float64 {
+	return math.Hypot(a.x-b.x, a.y-b.y)
+}
+
+/*
+Points of each cluster, and each cluster's centroid, from km1 output.
+Clusters without a "x y cN" line get the mean of their points.
+*/
+func readClusters(filename string) ([][]Point, []Point) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	found := make(map[int]Point)
+	members := make(map[int][]Point)
+	k := 0
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			log.Printf("%s line %d: %d fields, wanted 3\n", filename, lineNo, len(fields))
+			continue
+		}
+		x, errx := strconv.ParseFloat(fields[0], 64)
+		y, erry := strconv.ParseFloat(fields[1], 64)
+		if errx != nil || erry != nil {
+			log.Printf("%s line %d: bad coordinates\n", filename, lineNo)
+			continue
+		}
+		label := fields[2]
+		isCentroid := strings.HasPrefix(label, "c")
+		l, err := strconv.Atoi(strings.TrimPrefix(label, "c"))
+		if err != nil {
+			log.Printf("%s line %d: bad label %q\n", filename, lineNo, label)
+			continue
+		}
+		if l < 0 {
+			continue // noise points, labeled -1, belong to no cluster
+		}
+		if l >= k {
+			k = l + 1
+		}
+		if isCentroid {
+			found[l] = Point{x: x, y: y}
+			continue
+		}
+		members[l] = append(members[l], Point{x: x, y: y})
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	clusters := make([][]Point, k)
+	centroids := make([]Point, k)
+	for l := 0; l < k; l++ {
+		clusters[l] = members[l]
+		if c, ok := found[l]; ok {
+			centroids[l] = c
+			continue
+		}
+		for _, p := range clusters[l] {
+			centroids[l].x += p.x / float64(len(clusters[l]))
+			centroids[l].y += p.y / float64(len(clusters[l]))
+		}
+	}
+
+	return clusters, centroids
+}
@@ -0,0 +1,693 @@
+package main
+
+/*
+   Consensus clustering: combine many k-means runs into one partition.
+
+   Usage: consensus [-runs N] [-frac f] [-init random|pp|mixed] [-kmin N] [-kmax N]
+                    [-dense N] [-knn N] [-confidence file] filename k
+
+   Every km1 run comes out a little different. This runs kmeanscluster
+   -runs times, each time on a random -frac of the points, starting from
+   random centroids as in km1, k-means++ centroids as in km1a, or
+   alternating between the two (-init mixed). With -kmin and -kmax, each
+   run also picks its number of clusters at random from that range.
+
+   The co-association of two points is the fraction of runs, among those
+   that sampled both, that put them in the same cluster (Fred & Jain's
+   evidence accumulation). Up to -dense points, every pair gets counted.
+   Past that, the n*n matrix is too big, and only pairs of -knn nearest
+   neighbors get counted, a sparse approximation.
+
+   The consensus partition comes from average linkage on the
+   co-association matrix, cut at k clusters. A point's confidence is its mean co-association
+   with the other points of its consensus cluster, among the pairs counted.
+
+   Output is km1's format. Mean confidence per cluster goes to stderr,
+   and -confidence writes "x y label confidence" for every point.
+*/
+
+import (
+	"bufio"
+	"container/heap"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+type dist struct {
+	D2         float64
+	pointIndex int
+}
+
+// pair of points i < j, with how many runs sampled both,
+// and how many of those put them in the same cluster.
+type pair struct {
+	i, j     int32
+	sampled  uint16
+	together uint16
+}
+
+func (p pair) coassociation() float64 {
+	if p.sampled == 0 {
+		return 0
+	}
+	return float64(p.together) / float64(p.sampled)
+}
+
+func main() {
+	runs := flag.Int("runs", 50, "k-means runs in the ensemble")
+	frac := flag.Float64("frac", 0.8, "fraction of points each run clusters")
+	init := flag.String("init", "mixed", "initial centroids, random, pp (k-means++) or mixed")
+	kmin := flag.Int("kmin", 0, "smallest k for ensemble runs, defaults to k")
+	kmax := flag.Int("kmax", 0, "largest k for ensemble runs, defaults to k")
+	dense := flag.Int("dense", 3000, "most points for a full co-association matrix")
+	knn := flag.Int("knn", 30, "nearest neighbors per point in the sparse co-association matrix")
+	confidenceFile := flag.String("confidence", "", "write per-point confidence to this file")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: consensus [-runs N] [-frac f] [-init random|pp|mixed] [-kmin N] [-kmax N] [-dense N] [-knn N] [-confidence file] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	points := readPoints(flag.Arg(0))
+	n := len(points)
+	if k < 1 || k > n {
+		log.Fatalf("k %d out of range for %d points\n", k, n)
+	}
+	if *runs < 1 || *runs > math.MaxUint16 {
+		log.Fatalf("runs %d out of range 1 to %d\n", *runs, math.MaxUint16)
+	}
+	if *frac <= 0 || *frac > 1 {
+		log.Fatalf("frac %f out of range (0, 1]\n", *frac)
+	}
+	sampleSize := int(math.Round(*frac * float64(n)))
+	if sampleSize < 1 {
+		log.Fatalf("frac %f samples no points out of %d\n", *frac, n)
+	}
+	if distinct(points) < 2 {
+		log.Fatal("all points are the same, nothing to cluster")
+	}
+	if *init != "random" && *init != "pp" && *init != "mixed" {
+		log.Fatalf("unknown -init %q, want random, pp or mixed\n", *init)
+	}
+	if *kmin < 1 {
+		*kmin = k
+	}
+	if *kmax < *kmin {
+		*kmax = *kmin
+	}
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	var pairs []pair
+	if n <= *dense {
+		pairs = allPairs(n)
+	} else {
+		pairs = neighborPairs(points, *knn)
+		fmt.Fprintf(os.Stderr, "# %d points, sparse co-association over %d neighbor pairs\n", n, len(pairs))
+	}
+
+	labels := make([]int, n)
+	for run := 0; run < *runs; run++ {
+		sample := rand.Perm(n)[:sampleSize]
+		subset := make([]Point, len(sample))
+		for j, i := range sample {
+			subset[j] = points[i]
+		}
+
+		// randomCentroids needs kk different points to choose from.
+		kk := *kmin + rand.Intn(*kmax-*kmin+1)
+		if d := distinct(subset); kk > d {
+			kk = d
+		}
+
+		var centroids []Point
+		if *init == "random" || (*init == "mixed" && run%2 == 0) {
+			centroids = randomCentroids(kk, subset)
+		} else {
+			centroids = kMeansPPCentroids(kk, subset)
+		}
+
+		for i := range labels {
+			labels[i] = -1
+		}
+		for j, l := range kmeanscluster(centroids, subset) {
+			labels[sample[j]] = l
+		}
+
+		for p := range pairs {
+			li, lj := labels[pairs[p].i], labels[pairs[p].j]
+			if li < 0 || lj < 0 {
+				continue
+			}
+			pairs[p].sampled++
+			if li == lj {
+				pairs[p].together++
+			}
+		}
+	}
+
+	consensus, components := link(n, pairs, k)
+	if components > k {
+		fmt.Fprintf(os.Stderr, "# co-association graph falls apart into %d pieces, more than k = %d\n", components, k)
+	}
+	confidence := confidences(n, pairs, consensus)
+
+	clusters := make([][]int, components)
+	for i, l := range consensus {
+		clusters[l] = append(clusters[l], i)
+	}
+
+	for c, cluster := range clusters {
+		var sumx, sumy float64
+		for _, i := range cluster {
+			sumx += points[i].x
+			sumy += points[i].y
+		}
+		fmt.Printf("%f %f c%d\n", sumx/float64(len(cluster)), sumy/float64(len(cluster)), c)
+	}
+	for c, cluster := range clusters {
+		for _, i := range cluster {
+			fmt.Printf("%f %f %d\n", points[i].x, points[i].y, c)
+		}
+	}
+
+	for c, cluster := range clusters {
+		sum := 0.0
+		for _, i := range cluster {
+			sum += confidence[i]
+		}
+		fmt.Fprintf(os.Stderr, "# cluster %d, %d points, mean confidence %f\n", c, len(cluster), sum/float64(len(cluster)))
+	}
+
+	if *confidenceFile != "" {
+		fout, err := os.Create(*confidenceFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := bufio.NewWriter(fout)
+		for i, point := range points {
+			fmt.Fprintf(w, "%f %f %d %f\n", point.x, point.y, consensus[i], confidence[i])
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		if err := fout.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func allPairs(n int) []pair {
+	pairs := make([]pair, 0, n*(n-1)/2)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			pairs = append(pairs, pair{i: int32(i), j: int32(j)})
+		}
+	}
+	return pairs
+}
+
+// Every point paired with its knn nearest neighbors, each pair once.
+func neighborPairs(points []Point, knn int) []pair {
+	if knn > len(points)-1 {
+		knn = len(points) - 1
+	}
+	tree := newKDTree(points)
+	seen := make(map[[2]int32]bool)
+	var pairs []pair
+	for i := range points {
+		for _, j := range tree.nearest(i, knn) {
+			a, b := int32(i), int32(j)
+			if a > b {
+				a, b = b, a
+			}
+			if !seen[[2]int32{a, b}] {
+				seen[[2]int32{a, b}] = true
+				pairs = append(pairs, pair{i: a, j: b})
+			}
+		}
+	}
+	return pairs
+}
+
+/*
+Average linkage on co-association: repeatedly merge the two clusters
+with the highest mean co-association between their points, until only
+k clusters are left. Pairs that never got counted, in the sparse case,
+count as 0. Returns every point's cluster, numbered in order of first
+point, and how many clusters there are, which can be more than k if
+the sparse graph isn't connected.
+
+Merges come off a heap of candidate cluster pairs. Merging bumps the
+surviving cluster's version, and heap entries with a stale version
+get skipped.
+*/
+func link(n int, pairs []pair, k int) ([]int, int) {
+	// links[a][b] sums the co-association of pairs between clusters a and b.
+	links := make([]map[int]float64, n)
+	size := make([]float64, n)
+	version := make([]int, n)
+	parent := make([]int, n)
+	for i := range links {
+		links[i] = make(map[int]float64)
+		size[i] = 1
+		parent[i] = i
+	}
+	for _, p := range pairs {
+		if c := p.coassociation(); c > 0 {
+			links[p.i][int(p.j)] = c
+			links[p.j][int(p.i)] = c
+		}
+	}
+
+	h := &mergeHeap{}
+	for a := range links {
+		for b, sum := range links[a] {
+			if a < b {
+				*h = append(*h, merge{a: a, b: b, score: sum})
+			}
+		}
+	}
+	heap.Init(h)
+
+	components := n
+	for components > k && h.Len() > 0 {
+		m := heap.Pop(h).(merge)
+		if parent[m.a] != m.a || parent[m.b] != m.b ||
+			version[m.a] != m.va || version[m.b] != m.vb {
+			continue
+		}
+
+		a, b := m.a, m.b
+		if len(links[a]) < len(links[b]) {
+			a, b = b, a
+		}
+		for c, sum := range links[b] {
+			delete(links[c], b)
+			if c == a {
+				continue
+			}
+			links[a][c] += sum
+			links[c][a] += sum
+		}
+		delete(links[a], b)
+		links[b] = nil
+		parent[b] = a
+		size[a] += size[b]
+		version[a]++
+		components--
+
+		for c, sum := range links[a] {
+			heap.Push(h, merge{a: a, b: c, va: version[a], vb: version[c], score: sum / (size[a] * size[c])})
+		}
+	}
+
+	label := make(map[int]int)
+	labels := make([]int, n)
+	for i := range labels {
+		root := find(parent, i)
+		l, ok := label[root]
+		if !ok {
+			l = len(label)
+			label[root] = l
+		}
+		labels[i] = l
+	}
+
+	return labels, len(label)
+}
+
+// A candidate merge of clusters a and b, as of their versions va and vb.
+type merge struct {
+	a, b   int
+	va, vb int
+	score  float64
+}
+
+type mergeHeap []merge
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].score > h[j].score }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(merge)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	m := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return m
+}
+
+func find(parent []int, i int) int {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+// Mean co-association of each point with the counted pairs in its own cluster.
+func confidences(n int, pairs []pair, labels []int) []float64 {
+	sum := make([]float64, n)
+	count := make([]float64, n)
+	for _, p := range pairs {
+		i, j := int(p.i), int(p.j)
+		if labels[i] != labels[j] || p.sampled == 0 {
+			continue
+		}
+		c := p.coassociation()
+		sum[i] += c
+		sum[j] += c
+		count[i]++
+		count[j]++
+	}
+	confidence := make([]float64, n)
+	for i := range confidence {
+		if count[i] > 0 {
+			confidence[i] = sum[i] / count[i]
+		}
+	}
+	return confidence
+}
+
+/*
+Lloyd's algorithm from the given initial centroids,
+returning the cluster of every point.
+*/
+func kmeanscluster(centroids []Point, points []Point) []int {
+
+	k := len(centroids)
+
+	looping := true
+
+	labels := make([]int, len(points))
+
+	for looping {
+		for i, point := range points {
+			labels[i] = nearestCentroid(point, centroids)
+		}
+
+		newcentroids := calcCentroids(k, points, labels, centroids)
+		looping = compareCentroids(centroids, newcentroids)
+		centroids = newcentroids
+	}
+
+	return labels
+}
+
+func nearestCentroid(point Point, centroids []Point) int {
+	min := math.Inf(1)
+	cent := 0
+	for i, centroid := range centroids {
+		if d := sqDist(point, centroid); d < min {
+			min = d
+			cent = i
+		}
+	}
+	return cent
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+func compareCentroids(centroids []Point, newcentroids []Point) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		dx := centroids[i].x - newcentroids[i].x
+		dy := centroids[i].y - newcentroids[i].y
+		dist := dx*dx + dy*dy
+		if dist > 0.01 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+// An empty cluster keeps its old centroid.
+func calcCentroids(k int, points []Point, labels []int, old []Point) []Point {
+	centroids := make([]Point, k)
+	counts := make([]float64, k)
+
+	for i, point := range points {
+		centroids[labels[i]].x += point.x
+		centroids[labels[i]].y += point.y
+		counts[labels[i]]++
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			centroids[c] = old[c]
+			continue
+		}
+		centroids[c].x /= counts[c]
+		centroids[c].y /= counts[c]
+	}
+
+	return centroids
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
+
+// How many different points there are, duplicates counting once.
+func distinct(points []Point) int {
+	seen := make(map[Point]bool)
+	for _, p := range points {
+		seen[p] = true
+	}
+	return len(seen)
+}
+
+func randomCentroids(k int, points []Point) (centroids []Point) {
+
+	for len(centroids) < k {
+		candidate := points[rand.Intn(len(points))]
+		foundit := false
+		for _, centroid := range centroids {
+			if candidate.x == centroid.x && candidate.y == centroid.y {
+				foundit = true
+				break
+			}
+		}
+		if !foundit {
+			centroids = append(centroids, candidate)
+		}
+	}
+
+	return
+}
+
+/*
+		k-means++ method of finding initial guesses at centroids.
+
+	 1. Choose one center uniformly at random among the data points.
+	 2. For each data point x, compute D(x), the distance between x and the nearest
+	    center that has already been chosen.
+	 3. Choose one new data point at random as a new center, using a weighted
+	    probability distribution where a point x is chosen with probability
+	    proportional to D(x)^2.
+	 4. Repeat Steps 2 and 3 until k centers have been chosen.
+*/
+func kMeansPPCentroids(k int, points []Point) (centroids []Point) {
+
+	centroids = append(centroids, points[rand.Intn(len(points))])
+
+	D := make([]dist, len(points))
+
+	for i := 0; i < k-1; i++ {
+		fillDistances(D, points, centroids)
+		newCenterIndex := weightedChoice(D)
+		centroids = append(centroids, points[newCenterIndex])
+	}
+
+	return
+}
+
+type interval struct {
+	maxval float64
+	index  int
+}
+
+/*
+Choose one new data point at random as a new center, using a weighted
+probability distribution where a point x is chosen with probability
+proportional to D(x)^2.
+*/
+func weightedChoice(D []dist) int {
+	sumValues := 0.0
+
+	var intervals []interval
+
+	for i := range D {
+		sumValues += D[i].D2
+		intervals = append(intervals, interval{maxval: sumValues, index: D[i].pointIndex})
+	}
+
+	inInterval := rand.Float64() * sumValues
+
+	for i := range intervals {
+		if inInterval < intervals[i].maxval {
+			return intervals[i].index
+		}
+	}
+
+	return 0
+}
+
+/*
+For each data point x, compute D(x)^2, the squared distance between x
+and the nearest center that has already been chosen.
+*/
+func fillDistances(D []dist, points []Point, centroids []Point) {
+
+	for idx, point := range points {
+		minD := sqDist(point, centroids[0])
+
+		for _, center := range centroids {
+			if d := sqDist(point, center); d < minD {
+				minD = d
+			}
+		}
+
+		D[idx].D2 = minD
+		D[idx].pointIndex = idx
+	}
+}
+
+/*
+2-d tree of point indexes, for the nearest neighbor queries. Each node
+splits on x or y, alternating with depth, at the median of the points
+below it.
+*/
+type kdTree struct {
+	points []Point
+	root   *kdNode
+}
+
+type kdNode struct {
+	index       int
+	axis        int
+	left, right *kdNode
+}
+
+func newKDTree(points []Point) *kdTree {
+	indexes := make([]int, len(points))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	t := &kdTree{points: points}
+	t.root = t.build(indexes, 0)
+	return t
+}
+
+func (t *kdTree) build(indexes []int, depth int) *kdNode {
+	if len(indexes) == 0 {
+		return nil
+	}
+	axis := depth % 2
+	sort.Slice(indexes, func(i, j int) bool {
+		return coord(t.points[indexes[i]], axis) < coord(t.points[indexes[j]], axis)
+	})
+	mid := len(indexes) / 2
+	return &kdNode{
+		index: indexes[mid],
+		axis:  axis,
+		left:  t.build(indexes[:mid], depth+1),
+		right: t.build(indexes[mid+1:], depth+1),
+	}
+}
+
+func coord(p Point, axis int) float64 {
+	if axis == 0 {
+		return p.x
+	}
+	return p.y
+}
+
+// Indexes of the knn points nearest points[i], not counting itself, nearest first.
+func (t *kdTree) nearest(i int, knn int) []int {
+	p := t.points[i]
+	var found []int
+	var dists []float64
+
+	var search func(nd *kdNode)
+	search = func(nd *kdNode) {
+		if nd == nil {
+			return
+		}
+		if nd.index != i {
+			d := sqDist(p, t.points[nd.index])
+			if len(found) < knn || d < dists[len(dists)-1] {
+				at := sort.SearchFloat64s(dists, d)
+				found = append(found[:at], append([]int{nd.index}, found[at:]...)...)
+				dists = append(dists[:at], append([]float64{d}, dists[at:]...)...)
+				if len(found) > knn {
+					found = found[:knn]
+					dists = dists[:knn]
+				}
+			}
+		}
+
+		diff := coord(p, nd.axis) - coord(t.points[nd.index], nd.axis)
+		near, far := nd.left, nd.right
+		if diff > 0 {
+			near, far = far, near
+		}
+		search(near)
+		if len(found) < knn || diff*diff < dists[len(dists)-1] {
+			search(far)
+		}
+	}
+	search(t.root)
+	return found
+}
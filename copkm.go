@@ -0,0 +1,607 @@
+package main
+
+/*
+   k-means with must-link and cannot-link constraints.
+
+   Usage: copkm [-mode cop|pck] [-weight w] [-restarts N] constraints filename k
+
+   The constraints file has one constraint per line, "must i j" or
+   "cannot i j", where i and j are point IDs: the 0-based line number of
+   the point in filename. Blank lines and '#' comments in the constraints
+   file get skipped, but every line of filename has to be a point.
+   "must 3 17" says points 3 and 17 belong in the same cluster, "cannot
+   3 17" says they don't.
+
+   -mode cop is COP-k-means (Wagstaff, Cardie, Rogers & Schroedl), hard
+   constraints. Must-links are transitive, so points joined by chains of
+   must-links form groups that move as one. Every iteration visits the
+   groups in random order and puts each in the cluster with least total
+   squared distance that none of its cannot-link partners already occupy.
+   A cannot-link inside a must-link group can never be satisfied, and
+   copkm says so and which points are involved. Otherwise, a group can
+   still find every cluster blocked, because the greedy assignment isn't
+   a full search. Then copkm starts over from new centroids, up to
+   -restarts times, before giving up.
+
+   -mode pck is PCKMeans (Basu, Banerjee & Mooney), soft constraints.
+   A point's cost for a cluster is half its squared distance to the
+   centroid, plus w for every must-link partner in some other cluster
+   and w for every cannot-link partner in that one. w is -weight times
+   the variance of the points, so the default of 1 makes a violation
+   cost about as much as a typical point's distance from the mean.
+   Initial centroids come from the biggest must-link groups, as PCKMeans
+   does, then k-means++ for the rest.
+
+   Output is km1's format. How many constraints of each kind ended up
+   violated goes to stderr.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+type dist struct {
+	D2         float64
+	pointIndex int
+}
+
+// Constraint between points i and j, must-link or cannot-link.
+type Constraint struct {
+	i, j int
+	must bool
+}
+
+// link is one end of a constraint, seen from the other point.
+type link struct {
+	other int
+	must  bool
+}
+
+func main() {
+	mode := flag.String("mode", "cop", "cop for hard constraints, pck for soft")
+	weight := flag.Float64("weight", 1.0, "PCKMeans violation penalty, in units of the points' variance")
+	restarts := flag.Int("restarts", 10, "COP-k-means attempts before giving up")
+	flag.Parse()
+
+	if flag.NArg() < 3 {
+		log.Fatal("usage: copkm [-mode cop|pck] [-weight w] [-restarts N] constraints filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(2))
+	if err != nil {
+		log.Fatal(err)
+	}
+	points := readPoints(flag.Arg(1))
+	if k < 1 || k > len(points) {
+		log.Fatalf("k %d out of range for %d points\n", k, len(points))
+	}
+	constraints := readConstraints(flag.Arg(0), len(points))
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	var centroids []Point
+	var labels []int
+	switch *mode {
+	case "cop":
+		centroids, labels = copkmeans(k, points, constraints, *restarts)
+	case "pck":
+		centroids, labels = pckmeans(k, points, constraints, *weight*variance(points))
+	default:
+		log.Fatalf("unknown -mode %q, want cop or pck\n", *mode)
+	}
+
+	var musts, cannots, mustViolated, cannotViolated int
+	for _, c := range constraints {
+		same := labels[c.i] == labels[c.j]
+		if c.must {
+			musts++
+			if !same {
+				mustViolated++
+			}
+		} else {
+			cannots++
+			if same {
+				cannotViolated++
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "# %d of %d must-link, %d of %d cannot-link constraints violated\n",
+		mustViolated, musts, cannotViolated, cannots)
+
+	for i, centroid := range centroids {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+	for c := range centroids {
+		for i, point := range points {
+			if labels[i] == c {
+				fmt.Printf("%f %f %d\n", point.x, point.y, c)
+			}
+		}
+	}
+}
+
+// Lloyd-style iterations before giving up on centroids settling.
+const maxIterations = 100
+
+/*
+COP-k-means on must-link groups. Fails with a diagnostic if a cannot-link
+joins two points of one group, or if no restart finds an assignment.
+*/
+func copkmeans(k int, points []Point, constraints []Constraint, restarts int) ([]Point, []int) {
+	groups, groupOf := mustLinkGroups(len(points), constraints)
+
+	// Cannot-links between groups.
+	conflicts := make([]map[int]bool, len(groups))
+	for g := range conflicts {
+		conflicts[g] = make(map[int]bool)
+	}
+	for _, c := range constraints {
+		if c.must {
+			continue
+		}
+		gi, gj := groupOf[c.i], groupOf[c.j]
+		if gi == gj {
+			log.Fatalf("infeasible: cannot-link %d %d, but must-links put them in the same group of %d points: %s\n",
+				c.i, c.j, len(groups[gi]), joinInts(groups[gi]))
+		}
+		conflicts[gi][gj] = true
+		conflicts[gj][gi] = true
+	}
+
+	var stuck int
+	for attempt := 0; attempt < restarts; attempt++ {
+		centroids := kMeansPPCentroids(k, points)
+		groupLabels := make([]int, len(groups))
+		labels := make([]int, len(points))
+
+		ok := true
+		looping := true
+		for iterations := 0; looping && iterations < maxIterations; iterations++ {
+			var g int
+			if g, ok = assignGroups(points, groups, conflicts, centroids, groupLabels); !ok {
+				stuck = g
+				break
+			}
+			for g, group := range groups {
+				for _, i := range group {
+					labels[i] = groupLabels[g]
+				}
+			}
+			newcentroids := calcCentroids(k, points, labels, centroids)
+			looping = compareCentroids(centroids, newcentroids)
+			centroids = newcentroids
+		}
+		if ok {
+			if looping {
+				// Reshuffled group order can keep groups trading clusters.
+				fmt.Fprintf(os.Stderr, "# centroids still moving after %d iterations, stopping\n", maxIterations)
+			}
+			if attempt > 0 {
+				fmt.Fprintf(os.Stderr, "# found an assignment on attempt %d\n", attempt+1)
+			}
+			return centroids, labels
+		}
+	}
+
+	log.Fatalf("no assignment satisfies the cannot-links after %d attempts: the group of point %d (%d points) has cannot-links to %d other groups, and found all %d clusters taken. Try a bigger k.\n",
+		restarts, groups[stuck][0], len(groups[stuck]), len(conflicts[stuck]), k)
+	return nil, nil
+}
+
+/*
+One COP-k-means assignment pass. Groups, in random order, go to the
+cluster with least total squared distance that no cannot-link partner
+already holds. Returns false, and the group, if some group finds
+every cluster blocked.
+*/
+func assignGroups(points []Point, groups [][]int, conflicts []map[int]bool, centroids []Point, groupLabels []int) (int, bool) {
+	for g := range groupLabels {
+		groupLabels[g] = -1
+	}
+
+	costs := make([]float64, len(centroids))
+	order := make([]int, len(centroids))
+
+	for _, g := range rand.Perm(len(groups)) {
+		for c, centroid := range centroids {
+			costs[c] = 0
+			for _, i := range groups[g] {
+				costs[c] += sqDist(points[i], centroid)
+			}
+			order[c] = c
+		}
+		sort.Slice(order, func(a, b int) bool { return costs[order[a]] < costs[order[b]] })
+
+		groupLabels[g] = -1
+		for _, c := range order {
+			blocked := false
+			for other := range conflicts[g] {
+				if groupLabels[other] == c {
+					blocked = true
+					break
+				}
+			}
+			if !blocked {
+				groupLabels[g] = c
+				break
+			}
+		}
+		if groupLabels[g] < 0 {
+			return g, false
+		}
+	}
+
+	return 0, true
+}
+
+/*
+PCKMeans: each pass visits points in random order, giving each the
+cluster minimizing half its squared distance to the centroid, plus
+w per violated constraint, judged against its partners' current labels.
+*/
+func pckmeans(k int, points []Point, constraints []Constraint, w float64) ([]Point, []int) {
+	links := make([][]link, len(points))
+	for _, c := range constraints {
+		links[c.i] = append(links[c.i], link{other: c.j, must: c.must})
+		links[c.j] = append(links[c.j], link{other: c.i, must: c.must})
+	}
+
+	centroids := neighborhoodCentroids(k, points, constraints)
+
+	labels := make([]int, len(points))
+	for i := range labels {
+		labels[i] = -1
+	}
+
+	looping := true
+	for iterations := 0; looping && iterations < maxIterations; iterations++ {
+		for _, i := range rand.Perm(len(points)) {
+			min := math.Inf(1)
+			for c, centroid := range centroids {
+				cost := sqDist(points[i], centroid) / 2
+				for _, l := range links[i] {
+					other := labels[l.other]
+					if other < 0 {
+						continue
+					}
+					if l.must && other != c || !l.must && other == c {
+						cost += w
+					}
+				}
+				if cost < min {
+					min = cost
+					labels[i] = c
+				}
+			}
+		}
+
+		newcentroids := calcCentroids(k, points, labels, centroids)
+		looping = compareCentroids(centroids, newcentroids)
+		centroids = newcentroids
+	}
+	if looping {
+		fmt.Fprintf(os.Stderr, "# centroids still moving after %d iterations, stopping\n", maxIterations)
+	}
+
+	return centroids, labels
+}
+
+/*
+PCKMeans initialization: means of the biggest must-link groups of two
+or more points, topped up with k-means++ choices if there aren't k.
+*/
+func neighborhoodCentroids(k int, points []Point, constraints []Constraint) []Point {
+	groups, _ := mustLinkGroups(len(points), constraints)
+	sort.SliceStable(groups, func(a, b int) bool { return len(groups[a]) > len(groups[b]) })
+
+	var centroids []Point
+	for _, group := range groups {
+		if len(centroids) == k || len(group) < 2 {
+			break
+		}
+		var sum Point
+		for _, i := range group {
+			sum.x += points[i].x
+			sum.y += points[i].y
+		}
+		centroids = append(centroids, Point{x: sum.x / float64(len(group)), y: sum.y / float64(len(group))})
+	}
+
+	if len(centroids) == 0 {
+		centroids = append(centroids, points[rand.Intn(len(points))])
+	}
+	D := make([]dist, len(points))
+	for len(centroids) < k {
+		fillDistances(D, points, centroids)
+		centroids = append(centroids, points[weightedChoice(D)])
+	}
+
+	return centroids
+}
+
+/*
+Transitive closure of the must-links: groups of point IDs, each point
+in exactly one group, and the group of every point.
+*/
+func mustLinkGroups(n int, constraints []Constraint) ([][]int, []int) {
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	for _, c := range constraints {
+		if c.must {
+			parent[find(parent, c.i)] = find(parent, c.j)
+		}
+	}
+
+	groupOf := make([]int, n)
+	index := make(map[int]int)
+	var groups [][]int
+	for i := 0; i < n; i++ {
+		root := find(parent, i)
+		g, ok := index[root]
+		if !ok {
+			g = len(groups)
+			index[root] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+		groupOf[i] = g
+	}
+
+	return groups, groupOf
+}
+
+func find(parent []int, i int) int {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+// Mean squared distance of the points from their mean.
+func variance(points []Point) float64 {
+	var mean Point
+	for _, p := range points {
+		mean.x += p.x
+		mean.y += p.y
+	}
+	mean.x /= float64(len(points))
+	mean.y /= float64(len(points))
+
+	sum := 0.0
+	for _, p := range points {
+		sum += sqDist(p, mean)
+	}
+	return sum / float64(len(points))
+}
+
+func joinInts(xs []int) string {
+	s := make([]string, len(xs))
+	for i, x := range xs {
+		s[i] = strconv.Itoa(x)
+	}
+	return strings.Join(s, " ")
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+func compareCentroids(centroids []Point, newcentroids []Point) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		dx := centroids[i].x - newcentroids[i].x
+		dy := centroids[i].y - newcentroids[i].y
+		dist := dx*dx + dy*dy
+		if dist > 0.01 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+// An empty cluster keeps its old centroid.
+func calcCentroids(k int, points []Point, labels []int, old []Point) []Point {
+	centroids := make([]Point, k)
+	counts := make([]float64, k)
+
+	for i, point := range points {
+		centroids[labels[i]].x += point.x
+		centroids[labels[i]].y += point.y
+		counts[labels[i]]++
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			centroids[c] = old[c]
+			continue
+		}
+		centroids[c].x /= counts[c]
+		centroids[c].y /= counts[c]
+	}
+
+	return centroids
+}
+
+/*
+Read "must i j" and "cannot i j" lines. Point IDs are 0-based
+line numbers of points in the data file.
+*/
+func readConstraints(filename string, n int) []Constraint {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var constraints []Constraint
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 || (fields[0] != "must" && fields[0] != "cannot") {
+			log.Fatalf("%s line %d: want \"must i j\" or \"cannot i j\", got %q\n", filename, lineNo, scanner.Text())
+		}
+		i, erri := strconv.Atoi(fields[1])
+		j, errj := strconv.Atoi(fields[2])
+		if erri != nil || errj != nil {
+			log.Fatalf("%s line %d: bad point ID in %q\n", filename, lineNo, scanner.Text())
+		}
+		if i < 0 || i >= n || j < 0 || j >= n {
+			log.Fatalf("%s line %d: point ID out of range, %d points\n", filename, lineNo, n)
+		}
+		if i == j {
+			if fields[0] == "cannot" {
+				log.Fatalf("infeasible: %s line %d, point %d cannot-linked to itself\n", filename, lineNo, i)
+			}
+			continue
+		}
+		constraints = append(constraints, Constraint{i: i, j: j, must: fields[0] == "must"})
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return constraints
+}
+
+/*
+Read "x y" lines. Constraints name points by line number, so a line
+that isn't a point, even a blank one, is an error, not something to
+skip and shift every later point's ID.
+*/
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	scanner := bufio.NewScanner(fin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		var p Point
+		var errx, erry error
+		if len(fields) == 2 {
+			p.x, errx = strconv.ParseFloat(fields[0], 64)
+			p.y, erry = strconv.ParseFloat(fields[1], 64)
+		}
+		if len(fields) != 2 || errx != nil || erry != nil {
+			log.Fatalf("%s line %d, point ID %d: can't parse %q as \"x y\"\n",
+				filename, len(points)+1, len(points), scanner.Text())
+		}
+		points = append(points, p)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return points
+}
+
+/*
+k-means++ method of finding initial guesses at centroids.
+
+ 1. Choose one center uniformly at random among the data points.
+ 2. For each data point x, compute D(x), the distance between x and the nearest
+    center that has already been chosen.
+ 3. Choose one new data point at random as a new center, using a weighted
+    probability distribution where a point x is chosen with probability
+    proportional to D(x)^2.
+ 4. Repeat Steps 2 and 3 until k centers have been chosen.
+*/
+func kMeansPPCentroids(k int, points []Point) (centroids []Point) {
+
+	centroids = append(centroids, points[rand.Intn(len(points))])
+
+	D := make([]dist, len(points))
+
+	for i := 0; i < k-1; i++ {
+		fillDistances(D, points, centroids)
+		newCenterIndex := weightedChoice(D)
+		centroids = append(centroids, points[newCenterIndex])
+	}
+
+	return
+}
+
+type interval struct {
+	maxval float64
+	index  int
+}
+
+/*
+Choose one new data point at random as a new center, using a weighted
+probability distribution where a point x is chosen with probability
+proportional to D(x)^2.
+*/
+func weightedChoice(D []dist) int {
+	sumValues := 0.0
+
+	var intervals []interval
+
+	for i := range D {
+		sumValues += D[i].D2
+		intervals = append(intervals, interval{maxval: sumValues, index: D[i].pointIndex})
+	}
+
+	inInterval := rand.Float64() * sumValues
+
+	for i := range intervals {
+		if inInterval < intervals[i].maxval {
+			return intervals[i].index
+		}
+	}
+
+	return 0
+}
+
+/*
+For each data point x, compute D(x)^2, the squared distance between x
+and the nearest center that has already been chosen.
+*/
+func fillDistances(D []dist, points []Point, centroids []Point) {
+
+	for idx, point := range points {
+		minD := sqDist(point, centroids[0])
+
+		for _, center := range centroids {
+			if d := sqDist(point, center); d < minD {
+				minD = d
+			}
+		}
+
+		D[idx].D2 = minD
+		D[idx].pointIndex = idx
+	}
+}
@@ -0,0 +1,607 @@
+package main
+
+/*
+   Density-based clustering, as an alternative to km1 for data with noise.
+
+   Usage: dbscan [-mode dbscan|hdbscan] [-eps E] [-minpts N] [-minsize N] filename
+
+   k-means puts every point in some cluster, even genrand's uniformly
+   scattered noise. DBSCAN and HDBSCAN instead label points in sparse
+   regions as noise, with label -1.
+
+   DBSCAN (Ester et al.): a "core" point has at least minpts points,
+   itself included, within distance eps. Core points within eps of each
+   other share a cluster, and non-core points within eps of a core point
+   join its cluster. Everything else is noise.
+
+   HDBSCAN (Campello, Moulavi & Sander) doesn't need eps, so it copes with
+   clusters of different densities. It builds the minimum spanning tree of
+   "mutual reachability" distances, turns that into a hierarchy, condenses
+   the hierarchy by discarding splits that shed fewer than minsize points,
+   and keeps the condensed clusters that persist longest.
+
+   A k-d tree answers the eps-neighborhood and k-nearest-neighbor queries,
+   so they aren't O(n^2). HDBSCAN's minimum spanning tree still takes
+   O(n^2) time, but only O(n) memory.
+
+   Output is in km1's "x y label" format, with cluster means as "x y cN"
+   lines. Cluster and noise counts go to stderr as "# ..." lines.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+const noise = -1
+
+func main() {
+	mode := flag.String("mode", "dbscan", "clustering mode, dbscan or hdbscan")
+	eps := flag.Float64("eps", 10.0, "DBSCAN neighborhood radius")
+	minPts := flag.Int("minpts", 5, "points in a neighborhood, itself included, to make a core point")
+	minSize := flag.Int("minsize", 0, "HDBSCAN minimum cluster size, defaults to minpts")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: dbscan [-mode dbscan|hdbscan] [-eps E] [-minpts N] [-minsize N] filename")
+	}
+	if *minPts < 1 {
+		log.Fatalf("minpts %d must be at least 1\n", *minPts)
+	}
+	if *minSize < 1 {
+		*minSize = *minPts
+	}
+
+	points := readPoints(flag.Arg(0))
+	tree := newKDTree(points)
+
+	var labels []int
+	switch *mode {
+	case "dbscan":
+		labels = dbscan(points, tree, *eps, *minPts)
+		fmt.Fprintf(os.Stderr, "# dbscan eps %f minpts %d\n", *eps, *minPts)
+	case "hdbscan":
+		if *minSize < 2 {
+			*minSize = 2
+		}
+		labels = hdbscan(points, tree, *minPts, *minSize)
+		fmt.Fprintf(os.Stderr, "# hdbscan minpts %d minsize %d\n", *minPts, *minSize)
+	default:
+		log.Fatalf("unknown mode %q, want dbscan or hdbscan\n", *mode)
+	}
+
+	printClusters(points, labels)
+}
+
+func printClusters(points []Point, labels []int) {
+	k := 0
+	for _, label := range labels {
+		if label >= k {
+			k = label + 1
+		}
+	}
+
+	clusters := make([][]Point, k)
+	var noisePoints []Point
+	for i, label := range labels {
+		if label == noise {
+			noisePoints = append(noisePoints, points[i])
+			continue
+		}
+		clusters[label] = append(clusters[label], points[i])
+	}
+
+	fmt.Fprintf(os.Stderr, "# %d clusters, %d noise points\n", k, len(noisePoints))
+
+	for i, centroid := range calcCentroids(clusters) {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+
+	for i, cluster := range clusters {
+		for _, point := range cluster {
+			fmt.Printf("%f %f %d\n", point.x, point.y, i)
+		}
+	}
+
+	for _, point := range noisePoints {
+		fmt.Printf("%f %f %d\n", point.x, point.y, noise)
+	}
+}
+
+/*
+DBSCAN: expand a cluster outward from each unvisited core point,
+pulling in everything within eps of a core point in the cluster.
+*/
+func dbscan(points []Point, tree *kdTree, eps float64, minPts int) []int {
+	const unvisited = -2
+
+	labels := make([]int, len(points))
+	for i := range labels {
+		labels[i] = unvisited
+	}
+
+	cluster := 0
+	for i := range points {
+		if labels[i] != unvisited {
+			continue
+		}
+		neighbors := tree.within(points[i], eps)
+		if len(neighbors) < minPts {
+			labels[i] = noise
+			continue
+		}
+
+		labels[i] = cluster
+		queue := neighbors
+		for len(queue) > 0 {
+			j := queue[0]
+			queue = queue[1:]
+			if labels[j] == noise {
+				// border point, reachable but not core
+				labels[j] = cluster
+			}
+			if labels[j] != unvisited {
+				continue
+			}
+			labels[j] = cluster
+			if more := tree.within(points[j], eps); len(more) >= minPts {
+				queue = append(queue, more...)
+			}
+		}
+		cluster++
+	}
+
+	return labels
+}
+
+/*
+HDBSCAN:
+ 1. Core distance of a point is the distance to its minpts-th nearest
+    neighbor, itself included.
+ 2. Mutual reachability distance between a and b is the largest of
+    core(a), core(b) and the distance from a to b.
+ 3. Build the minimum spanning tree of mutual reachability distances,
+    and from it, the single-linkage hierarchy.
+ 4. Condense the hierarchy, select the most stable clusters, label points.
+*/
+func hdbscan(points []Point, tree *kdTree, minPts int, minSize int) []int {
+	n := len(points)
+	if n < minSize {
+		labels := make([]int, n)
+		for i := range labels {
+			labels[i] = noise
+		}
+		return labels
+	}
+
+	core := make([]float64, n)
+	for i := range points {
+		nearest := tree.nearest(points[i], minPts)
+		core[i] = distance(points[i], points[nearest[len(nearest)-1]])
+	}
+
+	edges := mutualReachabilityMST(points, core)
+	hierarchy := singleLinkage(n, edges)
+	condensed := condenseTree(hierarchy, n, minSize)
+
+	return condensed.labels(n)
+}
+
+type edge struct {
+	a, b int
+	d    float64
+}
+
+// Prim's algorithm on the complete graph of mutual reachability distances.
+func mutualReachabilityMST(points []Point, core []float64) []edge {
+	n := len(points)
+	inTree := make([]bool, n)
+	best := make([]float64, n)
+	from := make([]int, n)
+	for i := range best {
+		best[i] = math.Inf(1)
+	}
+
+	var edges []edge
+	current := 0
+	inTree[0] = true
+	for len(edges) < n-1 {
+		next := -1
+		for j := 0; j < n; j++ {
+			if inTree[j] {
+				continue
+			}
+			d := math.Max(distance(points[current], points[j]), math.Max(core[current], core[j]))
+			if d < best[j] {
+				best[j] = d
+				from[j] = current
+			}
+			if next < 0 || best[j] < best[next] {
+				next = j
+			}
+		}
+		inTree[next] = true
+		edges = append(edges, edge{a: from[next], b: next, d: best[next]})
+		current = next
+	}
+
+	return edges
+}
+
+// node of the single-linkage hierarchy. Leaves 0..n-1 are points,
+// internal nodes n..2n-2 are merges, in order of increasing distance.
+type node struct {
+	left, right int
+	d           float64
+	size        int
+}
+
+func singleLinkage(n int, edges []edge) []node {
+	sort.Slice(edges, func(i, j int) bool { return edges[i].d < edges[j].d })
+
+	tree := make([]node, n, 2*n-1)
+	for i := range tree {
+		tree[i] = node{left: -1, right: -1, size: 1}
+	}
+
+	parent := make([]int, n)
+	nodeOf := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+		nodeOf[i] = i
+	}
+
+	for _, e := range edges {
+		ra, rb := find(parent, e.a), find(parent, e.b)
+		left, right := nodeOf[ra], nodeOf[rb]
+		tree = append(tree, node{left: left, right: right, d: e.d, size: tree[left].size + tree[right].size})
+		parent[rb] = ra
+		nodeOf[ra] = len(tree) - 1
+	}
+
+	return tree
+}
+
+func find(parent []int, i int) int {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+// condensedCluster is a cluster of the condensed tree. Lambda is
+// 1/distance, so it grows as clusters shrink toward their densest cores.
+type condensedCluster struct {
+	parent    int
+	birth     float64 // lambda at which the cluster split off its parent
+	stability float64
+	children  []int
+	fellOut   []int // points that dropped out of this cluster as noise
+	selected  bool
+}
+
+type condensed struct {
+	clusters []condensedCluster
+}
+
+func lambda(d float64) float64 {
+	return 1 / math.Max(d, 1e-12)
+}
+
+/*
+Walk the single-linkage hierarchy from the top. A split where both
+sides have at least minSize points makes two new clusters. Otherwise
+the smaller side's points fall out of the current cluster, and the
+current cluster carries on down the bigger side.
+*/
+func condenseTree(tree []node, n int, minSize int) *condensed {
+	c := &condensed{}
+	c.clusters = append(c.clusters, condensedCluster{parent: -1})
+
+	type work struct {
+		node    int
+		cluster int
+	}
+	stack := []work{{node: len(tree) - 1, cluster: 0}}
+
+	for len(stack) > 0 {
+		w := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		nd := tree[w.node]
+		if w.node < n {
+			// A lone point reached on its own, the cluster shrank to nothing.
+			c.fallOut(w.cluster, []int{w.node}, math.Inf(1))
+			continue
+		}
+
+		l := lambda(nd.d)
+		left, right := tree[nd.left], tree[nd.right]
+
+		switch {
+		case left.size >= minSize && right.size >= minSize:
+			cl := &c.clusters[w.cluster]
+			cl.stability += float64(nd.size) * (l - cl.birth)
+			for _, child := range []int{nd.left, nd.right} {
+				c.clusters = append(c.clusters, condensedCluster{parent: w.cluster, birth: l})
+				id := len(c.clusters) - 1
+				c.clusters[w.cluster].children = append(c.clusters[w.cluster].children, id)
+				stack = append(stack, work{node: child, cluster: id})
+			}
+		case left.size < minSize && right.size < minSize:
+			c.fallOut(w.cluster, leaves(tree, w.node, n), l)
+		case left.size < minSize:
+			c.fallOut(w.cluster, leaves(tree, nd.left, n), l)
+			stack = append(stack, work{node: nd.right, cluster: w.cluster})
+		default:
+			c.fallOut(w.cluster, leaves(tree, nd.right, n), l)
+			stack = append(stack, work{node: nd.left, cluster: w.cluster})
+		}
+	}
+
+	c.selectClusters()
+
+	return c
+}
+
+func (c *condensed) fallOut(cluster int, points []int, l float64) {
+	cl := &c.clusters[cluster]
+	if math.IsInf(l, 1) {
+		l = cl.birth
+	}
+	cl.stability += float64(len(points)) * (l - cl.birth)
+	cl.fellOut = append(cl.fellOut, points...)
+}
+
+func leaves(tree []node, i int, n int) []int {
+	var pts []int
+	stack := []int{i}
+	for len(stack) > 0 {
+		j := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if j < n {
+			pts = append(pts, j)
+			continue
+		}
+		stack = append(stack, tree[j].left, tree[j].right)
+	}
+	return pts
+}
+
+/*
+Excess of mass selection: working up from the leaves, a cluster is
+selected if it's more stable than its selected descendants combined,
+in which case the descendants get unselected. The root, which is
+everything, never gets selected.
+*/
+func (c *condensed) selectClusters() {
+	// Children always come after their parent, so walk backwards.
+	subtree := make([]float64, len(c.clusters))
+	for i := len(c.clusters) - 1; i > 0; i-- {
+		cl := &c.clusters[i]
+		childSum := 0.0
+		for _, child := range cl.children {
+			childSum += subtree[child]
+		}
+		if cl.stability >= childSum {
+			cl.selected = true
+			c.unselectBelow(i)
+			subtree[i] = cl.stability
+		} else {
+			subtree[i] = childSum
+		}
+	}
+}
+
+func (c *condensed) unselectBelow(i int) {
+	for _, child := range c.clusters[i].children {
+		c.clusters[child].selected = false
+		c.unselectBelow(child)
+	}
+}
+
+/*
+A point belongs to the selected cluster it fell out of, or the selected
+cluster above that. Points with no selected cluster above them are noise.
+*/
+func (c *condensed) labels(n int) []int {
+	labels := make([]int, n)
+	for i := range labels {
+		labels[i] = noise
+	}
+
+	label := make([]int, len(c.clusters))
+	next := 0
+	for i := range c.clusters {
+		label[i] = noise
+		if c.clusters[i].selected {
+			label[i] = next
+			next++
+		}
+	}
+
+	for i, cl := range c.clusters {
+		owner := i
+		for owner >= 0 && !c.clusters[owner].selected {
+			owner = c.clusters[owner].parent
+		}
+		if owner < 0 {
+			continue
+		}
+		for _, p := range cl.fellOut {
+			labels[p] = label[owner]
+		}
+	}
+
+	return labels
+}
+
+/*
+2-d tree of point indexes. Each node splits on x or y, alternating
+with depth, at the median of the points below it.
+*/
+type kdTree struct {
+	points []Point
+	root   *kdNode
+}
+
+type kdNode struct {
+	index       int
+	axis        int
+	left, right *kdNode
+}
+
+func newKDTree(points []Point) *kdTree {
+	indexes := make([]int, len(points))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	t := &kdTree{points: points}
+	t.root = t.build(indexes, 0)
+	return t
+}
+
+func (t *kdTree) build(indexes []int, depth int) *kdNode {
+	if len(indexes) == 0 {
+		return nil
+	}
+	axis := depth % 2
+	sort.Slice(indexes, func(i, j int) bool {
+		return coord(t.points[indexes[i]], axis) < coord(t.points[indexes[j]], axis)
+	})
+	mid := len(indexes) / 2
+	return &kdNode{
+		index: indexes[mid],
+		axis:  axis,
+		left:  t.build(indexes[:mid], depth+1),
+		right: t.build(indexes[mid+1:], depth+1),
+	}
+}
+
+func coord(p Point, axis int) float64 {
+	if axis == 0 {
+		return p.x
+	}
+	return p.y
+}
+
+// Indexes of all points within distance r of p, p itself included.
+func (t *kdTree) within(p Point, r float64) []int {
+	var found []int
+	var search func(nd *kdNode)
+	search = func(nd *kdNode) {
+		if nd == nil {
+			return
+		}
+		if distance(p, t.points[nd.index]) <= r {
+			found = append(found, nd.index)
+		}
+		diff := coord(p, nd.axis) - coord(t.points[nd.index], nd.axis)
+		if diff <= r {
+			search(nd.left)
+		}
+		if diff >= -r {
+			search(nd.right)
+		}
+	}
+	search(t.root)
+	return found
+}
+
+// Indexes of the k points nearest p, p itself included, nearest first.
+func (t *kdTree) nearest(p Point, k int) []int {
+	var found []int
+	var dists []float64
+
+	var search func(nd *kdNode)
+	search = func(nd *kdNode) {
+		if nd == nil {
+			return
+		}
+		d := distance(p, t.points[nd.index])
+		if len(found) < k || d < dists[len(dists)-1] {
+			at := sort.SearchFloat64s(dists, d)
+			found = append(found[:at], append([]int{nd.index}, found[at:]...)...)
+			dists = append(dists[:at], append([]float64{d}, dists[at:]...)...)
+			if len(found) > k {
+				found = found[:k]
+				dists = dists[:k]
+			}
+		}
+
+		diff := coord(p, nd.axis) - coord(t.points[nd.index], nd.axis)
+		near, far := nd.left, nd.right
+		if diff > 0 {
+			near, far = far, near
+		}
+		search(near)
+		if len(found) < k || math.Abs(diff) < dists[len(dists)-1] {
+			search(far)
+		}
+	}
+	search(t.root)
+	return found
+}
+
+func distance(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+func calcCentroids(clusters [][]Point) []Point {
+	centroids := make([]Point, len(clusters))
+
+	for cent, cluster := range clusters {
+		var sumx, sumy float64
+		for _, point := range cluster {
+			sumx += point.x
+			sumy += point.y
+		}
+		centroids[cent] = Point{x: sumx / float64(len(cluster)), y: sumy / float64(len(cluster))}
+	}
+
+	return centroids
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
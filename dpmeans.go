@@ -0,0 +1,223 @@
+package main
+
+/*
+   DP-means clustering (Kulis & Jordan, "Revisiting k-means: New
+   Algorithms via Bayesian Nonparametrics").
+
+   Usage: dpmeans filename lambda
+
+   Instead of k, DP-means takes a penalty lambda, a squared distance.
+   It's Lloyd's algorithm like km1 runs, except that during assignment,
+   a point whose squared distance to every centroid exceeds lambda
+   opens a new cluster with itself as the centroid.
+   It minimizes sum of squared distances + lambda * (number of clusters).
+
+   The number of clusters found and the objective go to stderr as "# ..."
+   lines, the centroids and labeled points go to stdout like km1's output.
+*/
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		log.Fatal("usage: dpmeans filename lambda")
+	}
+	lambda, err := strconv.ParseFloat(os.Args[2], 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if lambda <= 0 {
+		log.Fatalf("lambda %f must be positive\n", lambda)
+	}
+	filename := os.Args[1]
+	points := readPoints(filename)
+	if len(points) == 0 {
+		log.Fatalf("no points in %s\n", filename)
+	}
+
+	ps := PointSlice(points)
+	sort.Sort(ps)
+
+	dpmeanscluster(lambda, points)
+}
+
+func dpmeanscluster(lambda float64, points []Point) {
+
+	// DP-means starts with a single cluster, the mean of all the points.
+	centroids := calcCentroids([][]Point{points})
+
+	looping := true
+
+	var finalclusters [][]Point
+	var objective float64
+
+	for looping {
+
+		var clusters [][]Point
+		for range centroids {
+			clusters = append(clusters, nil)
+		}
+
+		for _, point := range points {
+			cent, min := nearestCentroid(point, centroids)
+			if min > lambda {
+				centroids = append(centroids, point)
+				clusters = append(clusters, nil)
+				cent = len(centroids) - 1
+			}
+			clusters[cent] = append(clusters[cent], point)
+		}
+
+		clusters = dropEmpty(clusters)
+		newcentroids := calcCentroids(clusters)
+
+		objective = dpObjective(lambda, clusters, newcentroids)
+		fmt.Fprintf(os.Stderr, "# %d clusters, objective %f\n", len(newcentroids), objective)
+
+		looping = len(newcentroids) != len(centroids) || compareCentroids(centroids, newcentroids)
+
+		centroids = newcentroids
+		finalclusters = clusters
+	}
+
+	fmt.Fprintf(os.Stderr, "# DP-means lambda %f found k = %d, objective %f\n", lambda, len(centroids), objective)
+
+	for i, centroid := range centroids {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+
+	for i, cluster := range finalclusters {
+		for _, point := range cluster {
+			fmt.Printf("%f %f %d\n", point.x, point.y, i)
+		}
+	}
+}
+
+// Index of, and squared distance to, the centroid nearest point.
+func nearestCentroid(point Point, centroids []Point) (int, float64) {
+	min := math.Inf(1)
+	cent := 0
+	for i, centroid := range centroids {
+		dx := centroid.x - point.x
+		dy := centroid.y - point.y
+		if d := dx*dx + dy*dy; d < min {
+			min = d
+			cent = i
+		}
+	}
+	return cent, min
+}
+
+// A cluster can lose all its points when a newer cluster opens nearby.
+func dropEmpty(clusters [][]Point) [][]Point {
+	var kept [][]Point
+	for _, cluster := range clusters {
+		if len(cluster) > 0 {
+			kept = append(kept, cluster)
+		}
+	}
+	return kept
+}
+
+// Sum of squared distances to centroids, plus lambda per cluster.
+func dpObjective(lambda float64, clusters [][]Point, centroids []Point) float64 {
+	sse := 0.0
+	for i, cluster := range clusters {
+		for _, point := range cluster {
+			dx := centroids[i].x - point.x
+			dy := centroids[i].y - point.y
+			sse += dx*dx + dy*dy
+		}
+	}
+	return sse + lambda*float64(len(centroids))
+}
+
+func compareCentroids(centroids []Point, newcentroids []Point) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		dx := centroids[i].x - newcentroids[i].x
+		dy := centroids[i].y - newcentroids[i].y
+		dist := dx*dx + dy*dy
+		if dist > 0.01 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+type PointSlice []Point
+
+func (ps PointSlice) Len() int { return len(ps) }
+func (ps PointSlice) Less(i, j int) bool {
+	if ps[i].x < ps[j].x {
+		return true
+	}
+	if ps[i].x == ps[j].x {
+		return ps[i].y < ps[j].y
+	}
+	return false
+}
+func (ps PointSlice) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+
+func calcCentroids(clusters [][]Point) []Point {
+	centroids := make([]Point, len(clusters))
+
+	for cent, cluster := range clusters {
+		var sumx, sumy float64
+		for _, point := range cluster {
+			sumx += point.x
+			sumy += point.y
+		}
+		centroids[cent] = Point{x: sumx / float64(len(cluster)), y: sumy / float64(len(cluster))}
+	}
+
+	return centroids
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
@@ -0,0 +1,303 @@
+package main
+
+/*
+   Compare a clustering against ground truth labels.
+
+   Usage: evaluate truthfile filename
+
+   truthfile has "x y label" lines, as from genblob -l. filename is km1
+   output, or any other program's output in km1's format; its "x y cN"
+   centroid lines get skipped. Points get matched up by coordinates,
+   and a noise label like dbscan's -1 counts as one more cluster.
+
+   Prints:
+   Adjusted Rand Index - pair counting agreement, corrected for chance.
+       1 is perfect, 0 is what random labels get.
+   Normalized Mutual Information - I(T;C)/sqrt(H(T)H(C)), 1 is perfect.
+   Purity - fraction of points in their cluster's most common true class.
+   Homogeneity, completeness and V-measure (Rosenberg & Hirschberg).
+       Homogeneous clusters each hold a single class, complete classes
+       each fall in a single cluster, V-measure is their harmonic mean.
+   The confusion matrix, true classes down, found clusters across.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		log.Fatal("usage: evaluate truthfile filename")
+	}
+
+	truthPoints, truthLabels := readLabeled(os.Args[1])
+	points, labels := readLabeled(os.Args[2])
+
+	// Truth label of every point, by coordinates, counting duplicates.
+	truth := make(map[Point][]int)
+	for i, p := range truthPoints {
+		truth[p] = append(truth[p], truthLabels[i])
+	}
+
+	var pairs [][2]int // true class, found cluster
+	unmatched := 0
+	for i, p := range points {
+		ts := truth[p]
+		if len(ts) == 0 {
+			unmatched++
+			continue
+		}
+		pairs = append(pairs, [2]int{ts[0], labels[i]})
+		truth[p] = ts[1:]
+	}
+	if unmatched > 0 {
+		log.Printf("%d points in %s not in %s\n", unmatched, os.Args[2], os.Args[1])
+	}
+	if len(pairs) == 0 {
+		log.Fatal("no points in common")
+	}
+
+	classes, clusters, table := contingency(pairs)
+
+	h, c, v := vMeasure(table)
+
+	fmt.Printf("points              %d\n", len(pairs))
+	fmt.Printf("classes             %d\n", len(classes))
+	fmt.Printf("clusters            %d\n", len(clusters))
+	fmt.Printf("ARI                 %f\n", adjustedRandIndex(table))
+	fmt.Printf("NMI                 %f\n", normalizedMutualInformation(table))
+	fmt.Printf("purity              %f\n", purity(table))
+	fmt.Printf("homogeneity         %f\n", h)
+	fmt.Printf("completeness        %f\n", c)
+	fmt.Printf("V-measure           %f\n", v)
+
+	fmt.Printf("\nconfusion matrix, true class down, cluster across\n")
+	fmt.Printf("%8s", "")
+	for _, cl := range clusters {
+		fmt.Printf(" %7d", cl)
+	}
+	fmt.Printf("\n")
+	for i, class := range classes {
+		fmt.Printf("%8d", class)
+		for j := range clusters {
+			fmt.Printf(" %7.0f", table[i][j])
+		}
+		fmt.Printf("\n")
+	}
+}
+
+/*
+Contingency table, table[i][j] the number of points with true class
+classes[i] in found cluster clusters[j].
+*/
+func contingency(pairs [][2]int) ([]int, []int, [][]float64) {
+	classIndex := make(map[int]int)
+	clusterIndex := make(map[int]int)
+	var classes, clusters []int
+	for _, p := range pairs {
+		if _, ok := classIndex[p[0]]; !ok {
+			classIndex[p[0]] = 0
+			classes = append(classes, p[0])
+		}
+		if _, ok := clusterIndex[p[1]]; !ok {
+			clusterIndex[p[1]] = 0
+			clusters = append(clusters, p[1])
+		}
+	}
+	sort.Ints(classes)
+	sort.Ints(clusters)
+	for i, class := range classes {
+		classIndex[class] = i
+	}
+	for j, cluster := range clusters {
+		clusterIndex[cluster] = j
+	}
+
+	table := make([][]float64, len(classes))
+	for i := range table {
+		table[i] = make([]float64, len(clusters))
+	}
+	for _, p := range pairs {
+		table[classIndex[p[0]]][clusterIndex[p[1]]]++
+	}
+
+	return classes, clusters, table
+}
+
+func sums(table [][]float64) (rows, cols []float64, n float64) {
+	rows = make([]float64, len(table))
+	cols = make([]float64, len(table[0]))
+	for i := range table {
+		for j, nij := range table[i] {
+			rows[i] += nij
+			cols[j] += nij
+			n += nij
+		}
+	}
+	return
+}
+
+func choose2(n float64) float64 { return n * (n - 1) / 2 }
+
+/*
+ARI = (index - expected) / (max - expected), where index is the
+sum over cells of C(n_ij, 2), expected is sum C(a_i, 2) * sum C(b_j, 2)
+/ C(n, 2), and max is the mean of sum C(a_i, 2) and sum C(b_j, 2).
+*/
+func adjustedRandIndex(table [][]float64) float64 {
+	rows, cols, n := sums(table)
+
+	index := 0.0
+	for i := range table {
+		for _, nij := range table[i] {
+			index += choose2(nij)
+		}
+	}
+	sumA, sumB := 0.0, 0.0
+	for _, a := range rows {
+		sumA += choose2(a)
+	}
+	for _, b := range cols {
+		sumB += choose2(b)
+	}
+
+	expected := sumA * sumB / choose2(n)
+	max := (sumA + sumB) / 2
+	if max == expected {
+		// Both partitions trivial, all one cluster or all singletons.
+		return 1
+	}
+	return (index - expected) / (max - expected)
+}
+
+func entropy(counts []float64, n float64) float64 {
+	h := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			h -= c / n * math.Log(c/n)
+		}
+	}
+	return h
+}
+
+func mutualInformation(table [][]float64) float64 {
+	rows, cols, n := sums(table)
+	mi := 0.0
+	for i := range table {
+		for j, nij := range table[i] {
+			if nij > 0 {
+				mi += nij / n * math.Log(n*nij/(rows[i]*cols[j]))
+			}
+		}
+	}
+	return mi
+}
+
+func normalizedMutualInformation(table [][]float64) float64 {
+	rows, cols, n := sums(table)
+	hT, hC := entropy(rows, n), entropy(cols, n)
+	if hT == 0 || hC == 0 {
+		if hT == hC {
+			return 1
+		}
+		return 0
+	}
+	return mutualInformation(table) / math.Sqrt(hT*hC)
+}
+
+func purity(table [][]float64) float64 {
+	_, cols, n := sums(table)
+	total := 0.0
+	for j := range cols {
+		max := 0.0
+		for i := range table {
+			if table[i][j] > max {
+				max = table[i][j]
+			}
+		}
+		total += max
+	}
+	return total / n
+}
+
+/*
+homogeneity  = 1 - H(T|C)/H(T)
+completeness = 1 - H(C|T)/H(C)
+Using H(T|C) = H(T) - I(T;C), those come out to I/H(T) and I/H(C).
+*/
+func vMeasure(table [][]float64) (float64, float64, float64) {
+	rows, cols, n := sums(table)
+	hT, hC := entropy(rows, n), entropy(cols, n)
+	mi := mutualInformation(table)
+
+	h, c := 1.0, 1.0
+	if hT > 0 {
+		h = mi / hT
+	}
+	if hC > 0 {
+		c = mi / hC
+	}
+	if h+c == 0 {
+		return h, c, 0
+	}
+	return h, c, 2 * h * c / (h + c)
+}
+
+/*
+Read "x y label" lines, skipping "x y cN" centroid lines,
+blank lines and '#' comments.
+*/
+func readLabeled(filename string) ([]Point, []int) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+	var labels []int
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			log.Printf("%s line %d: %d fields, wanted 3\n", filename, lineNo, len(fields))
+			continue
+		}
+		if strings.HasPrefix(fields[2], "c") {
+			continue
+		}
+		x, errx := strconv.ParseFloat(fields[0], 64)
+		y, erry := strconv.ParseFloat(fields[1], 64)
+		label, errl := strconv.Atoi(fields[2])
+		if errx != nil || erry != nil || errl != nil {
+			log.Printf("%s line %d: can't parse %q\n", filename, lineNo, scanner.Text())
+			continue
+		}
+		points = append(points, Point{x: x, y: y})
+		labels = append(labels, label)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return points, labels
+}
@@ -0,0 +1,450 @@
+package main
+
+/*
+   Explain a clustering with readable rules, an IMM threshold tree.
+
+   Usage: explain [-labels file] filename
+
+   filename is km1 output, or anything else in km1's format. Clusters
+   without an "x y cN" centroid line get the mean of their points, and
+   noise points, labeled -1, get left out.
+
+   "Cluster 4" means nothing to someone who can't see the plot. A
+   decision tree with k leaves, each node cutting on a single coordinate,
+   turns every cluster into a rule like
+
+       x < 742.3 && y >= 120.8 -> cluster 2
+
+   The Iterative Mistake Minimization algorithm (Dasgupta, Frost,
+   Moshkovitz & Rashtchian) builds the tree top down. Every node holds
+   some centroids, and the points belonging to them. Of all the cuts
+   that separate at least two of its centroids, it takes the one putting
+   the fewest points on the other side from their own centroid, the
+   mistakes. Mistakes drop out of the rest of the construction, and both
+   sides get cut again until each leaf has one centroid. Thresholds get
+   rounded to the fewest decimals that still split the same points.
+
+   The tree's rules assign every point, mistakes included, to a cluster,
+   which can cost more than the original clustering. Prints the rules,
+   then the k-means cost, the sum of squared distances to centroids, of
+   the original clustering, and of the tree's, with the original
+   centroids and with the means of the tree's clusters, and how many
+   points the tree puts in a different cluster. -labels writes the
+   tree's clustering in km1's format.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+// Node of the threshold tree. Inner nodes send points with
+// coordinate axis below threshold left, the rest right.
+type Node struct {
+	axis      int
+	threshold float64
+	digits    int
+	left      *Node
+	right     *Node
+	cluster   int // leaves only
+}
+
+var axisNames = []string{"x", "y"}
+
+func main() {
+	labelsFile := flag.String("labels", "", "write the tree's clustering to this file")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: explain [-labels file] filename")
+	}
+
+	points, labels, centroids, names := readClustering(flag.Arg(0))
+	if len(centroids) == 0 {
+		log.Fatalf("no clusters in %s\n", flag.Arg(0))
+	}
+
+	members := make([]int, len(points))
+	for i := range members {
+		members[i] = i
+	}
+	clusters := make([]int, len(centroids))
+	for c := range clusters {
+		clusters[c] = c
+	}
+	tree, mistakes := build(points, labels, centroids, members, clusters)
+
+	printRules(tree, nil, names)
+
+	treeLabels := make([]int, len(points))
+	changed := 0
+	for i, p := range points {
+		treeLabels[i] = tree.classify(p)
+		if treeLabels[i] != labels[i] {
+			changed++
+		}
+	}
+
+	means := calcCentroids(len(centroids), points, treeLabels, centroids)
+	original, withCentroids, withMeans := 0.0, 0.0, 0.0
+	for i, p := range points {
+		original += sqDist(p, centroids[labels[i]])
+		withCentroids += sqDist(p, centroids[treeLabels[i]])
+		withMeans += sqDist(p, means[treeLabels[i]])
+	}
+
+	fmt.Printf("\n%d mistakes while building the tree, %d of %d points change cluster\n", mistakes, changed, len(points))
+	fmt.Printf("cost %f original clustering\n", original)
+	fmt.Printf("cost %f tree, original centroids%s\n", withCentroids, change(withCentroids, original))
+	fmt.Printf("cost %f tree, its own means%s\n", withMeans, change(withMeans, original))
+
+	if *labelsFile != "" {
+		fout, err := os.Create(*labelsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := bufio.NewWriter(fout)
+		for c, centroid := range means {
+			fmt.Fprintf(w, "%f %f c%d\n", centroid.x, centroid.y, names[c])
+		}
+		for c := range means {
+			for i, p := range points {
+				if treeLabels[i] == c {
+					fmt.Fprintf(w, "%f %f %d\n", p.x, p.y, names[c])
+				}
+			}
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		if err := fout.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+/*
+Build the subtree separating clusters, from the points in members that
+belong to them. Returns the subtree and the number of mistakes made.
+*/
+func build(points []Point, labels []int, centroids []Point, members []int, clusters []int) (*Node, int) {
+	if len(clusters) == 1 {
+		return &Node{cluster: clusters[0]}, 0
+	}
+
+	axis, threshold, digits, mistakes := bestCut(points, labels, centroids, members, clusters)
+
+	var leftClusters, rightClusters []int
+	side := make(map[int]bool) // true for left
+	for _, c := range clusters {
+		side[c] = coord(centroids[c], axis) < threshold
+		if side[c] {
+			leftClusters = append(leftClusters, c)
+		} else {
+			rightClusters = append(rightClusters, c)
+		}
+	}
+
+	var leftMembers, rightMembers []int
+	for _, i := range members {
+		left := coord(points[i], axis) < threshold
+		if left != side[labels[i]] {
+			continue // a mistake
+		}
+		if left {
+			leftMembers = append(leftMembers, i)
+		} else {
+			rightMembers = append(rightMembers, i)
+		}
+	}
+
+	left, leftMistakes := build(points, labels, centroids, leftMembers, leftClusters)
+	right, rightMistakes := build(points, labels, centroids, rightMembers, rightClusters)
+
+	node := &Node{axis: axis, threshold: threshold, digits: digits, left: left, right: right}
+	return node, mistakes + leftMistakes + rightMistakes
+}
+
+type event struct {
+	v        float64
+	cluster  int
+	centroid bool
+}
+
+/*
+The cut with fewest mistakes that leaves at least one centroid on each
+side. Sweeps each axis in order, counting below[c], the points of
+cluster c below the threshold so far. With c's centroid below, c's
+points above are mistakes, otherwise its points below are. Ties go to
+the cut with the widest gap. Returns the axis, threshold, decimals to
+print it with, and mistakes.
+*/
+func bestCut(points []Point, labels []int, centroids []Point, members []int, clusters []int) (int, float64, int, int) {
+	bestAxis, bestThreshold, bestDigits := -1, 0.0, 0
+	bestMistakes, bestGap := math.MaxInt32, -1.0
+
+	for axis := range axisNames {
+		var events []event
+		total := make(map[int]int)
+		for _, i := range members {
+			events = append(events, event{v: coord(points[i], axis), cluster: labels[i]})
+			total[labels[i]]++
+		}
+		for _, c := range clusters {
+			events = append(events, event{v: coord(centroids[c], axis), cluster: c, centroid: true})
+		}
+		sort.Slice(events, func(a, b int) bool { return events[a].v < events[b].v })
+
+		below := make(map[int]int)
+		centroidBelow := make(map[int]bool)
+		centroidsBelow := 0
+		mistakes := 0
+		for e := 0; e < len(events); e++ {
+			ev := events[e]
+			if ev.centroid {
+				mistakes += total[ev.cluster] - 2*below[ev.cluster]
+				centroidBelow[ev.cluster] = true
+				centroidsBelow++
+			} else {
+				below[ev.cluster]++
+				if centroidBelow[ev.cluster] {
+					mistakes--
+				} else {
+					mistakes++
+				}
+			}
+
+			// Cut only between distinct values, with centroids on both sides.
+			if e+1 == len(events) || events[e+1].v == ev.v {
+				continue
+			}
+			if centroidsBelow == 0 || centroidsBelow == len(clusters) {
+				continue
+			}
+			gap := events[e+1].v - ev.v
+			if mistakes < bestMistakes || mistakes == bestMistakes && gap > bestGap {
+				bestAxis, bestMistakes, bestGap = axis, mistakes, gap
+				bestThreshold, bestDigits = roundBetween(ev.v, events[e+1].v)
+			}
+		}
+	}
+
+	if bestAxis < 0 {
+		log.Fatal("can't separate centroids, some have the same coordinates")
+	}
+	return bestAxis, bestThreshold, bestDigits, bestMistakes
+}
+
+// The number with the fewest decimals in (lo, hi], and how many decimals.
+func roundBetween(lo, hi float64) (float64, int) {
+	for digits := 0; digits < 10; digits++ {
+		scale := math.Pow(10, float64(digits))
+		t := (math.Floor(lo*scale) + 1) / scale
+		if t > lo && t <= hi {
+			return t, digits
+		}
+	}
+	return (lo + hi) / 2, 10
+}
+
+func (n *Node) classify(p Point) int {
+	for n.left != nil {
+		if coord(p, n.axis) < n.threshold {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return n.cluster
+}
+
+// A bound on one axis, on the way down to a leaf.
+type condition struct {
+	axis      int
+	below     bool
+	threshold float64
+	digits    int
+}
+
+// Print a rule per leaf, keeping only the tightest bound of each kind on each axis.
+// Cluster c gets printed as its label in the input, names[c].
+func printRules(n *Node, path []condition, names []int) {
+	if n.left != nil {
+		left := append(append([]condition(nil), path...), condition{n.axis, true, n.threshold, n.digits})
+		right := append(append([]condition(nil), path...), condition{n.axis, false, n.threshold, n.digits})
+		printRules(n.left, left, names)
+		printRules(n.right, right, names)
+		return
+	}
+
+	var terms []string
+	for axis, name := range axisNames {
+		var lower, upper *condition
+		for i := range path {
+			c := &path[i]
+			if c.axis != axis {
+				continue
+			}
+			if c.below && (upper == nil || c.threshold < upper.threshold) {
+				upper = c
+			}
+			if !c.below && (lower == nil || c.threshold > lower.threshold) {
+				lower = c
+			}
+		}
+		if lower != nil {
+			terms = append(terms, fmt.Sprintf("%s >= %.*f", name, lower.digits, lower.threshold))
+		}
+		if upper != nil {
+			terms = append(terms, fmt.Sprintf("%s < %.*f", name, upper.digits, upper.threshold))
+		}
+	}
+	if len(terms) == 0 {
+		terms = append(terms, "true")
+	}
+	fmt.Printf("%s -> cluster %d\n", strings.Join(terms, " && "), names[n.cluster])
+}
+
+// Percent change in cost from original, if there's any original cost to compare to.
+func change(cost, original float64) string {
+	if original == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %+.2f%%", 100*(cost-original)/original)
+}
+
+func coord(p Point, axis int) float64 {
+	if axis == 0 {
+		return p.x
+	}
+	return p.y
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+// An empty cluster keeps its old centroid.
+func calcCentroids(k int, points []Point, labels []int, old []Point) []Point {
+	centroids := make([]Point, k)
+	counts := make([]float64, k)
+
+	for i, point := range points {
+		centroids[labels[i]].x += point.x
+		centroids[labels[i]].y += point.y
+		counts[labels[i]]++
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			centroids[c] = old[c]
+			continue
+		}
+		centroids[c].x /= counts[c]
+		centroids[c].y /= counts[c]
+	}
+
+	return centroids
+}
+
+/*
+Read km1 output: points, their labels, and centroids. Centroid lines
+look like "x y cN", point lines like "x y N". Clusters without a
+centroid line get the mean of their points. Noise, labeled -1, and
+'#' comments get skipped, and so do labels with neither a centroid
+nor points, gaps like dbscan or relabel can leave. The rest get
+renumbered 0..k-1, and names maps them back to their labels.
+*/
+func readClustering(filename string) ([]Point, []int, []Point, []int) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+	var labels []int
+	found := make(map[int]Point)
+	k := 0
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			log.Printf("%s line %d: %d fields, wanted 3\n", filename, lineNo, len(fields))
+			continue
+		}
+		x, errx := strconv.ParseFloat(fields[0], 64)
+		y, erry := strconv.ParseFloat(fields[1], 64)
+		if errx != nil || erry != nil {
+			log.Printf("%s line %d: bad coordinates\n", filename, lineNo)
+			continue
+		}
+		label := fields[2]
+		isCentroid := strings.HasPrefix(label, "c")
+		l, err := strconv.Atoi(strings.TrimPrefix(label, "c"))
+		if err != nil {
+			log.Printf("%s line %d: bad label %q\n", filename, lineNo, label)
+			continue
+		}
+		if l < 0 {
+			continue // noise points, labeled -1, belong to no cluster
+		}
+		if l >= k {
+			k = l + 1
+		}
+		if isCentroid {
+			found[l] = Point{x: x, y: y}
+			continue
+		}
+		points = append(points, Point{x: x, y: y})
+		labels = append(labels, l)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	means := calcCentroids(k, points, labels, make([]Point, k))
+	counts := make([]int, k)
+	for _, l := range labels {
+		counts[l]++
+	}
+
+	var centroids []Point
+	var names []int
+	index := make([]int, k)
+	for l := 0; l < k; l++ {
+		index[l] = len(centroids)
+		if c, ok := found[l]; ok {
+			centroids = append(centroids, c)
+			names = append(names, l)
+		} else if counts[l] > 0 {
+			centroids = append(centroids, means[l])
+			names = append(names, l)
+		}
+	}
+	for i, l := range labels {
+		labels[i] = index[l]
+	}
+
+	return points, labels, centroids, names
+}
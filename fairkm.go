@@ -0,0 +1,502 @@
+package main
+
+/*
+   Fair k-means: every cluster holds about the same mix of a protected
+   attribute's groups as the data as a whole.
+
+   Usage: fairkm [-delta d] filename k
+
+   Reads "x y group" lines, the group being any word or number, like a
+   demographic category, or genblob -l's blob index.
+
+   Balance-constrained assignment, after Bera, Chakrabarty, Flores &
+   Negahbani. If group g is fraction p of all points, and cluster c ends
+   up with s points, c should get close to p*s of g's points. Each
+   iteration:
+
+   1. Cluster sizes s come from assigning every point to its nearest
+      centroid, as plain k-means would.
+   2. Group g's quota in cluster c is between (1-delta) and (1+delta)
+      times p*s, rounded outward to whole points.
+   3. Each group's points get assigned to clusters by minimum cost flow,
+      least total squared distance to centroids within the quotas, the
+      same successive shortest paths km3 uses.
+   4. Centroids move to the means of their clusters.
+
+   until the centroids settle, at most 100 times.
+
+   Output is km1's format. For each cluster, stderr gets its size, the
+   count of each group, and its balance: the smallest, over groups, of
+   the group's share of the cluster over its share of all points, or the
+   inverse, whichever is less than 1. Balance 1 is a perfect mix, 0 means
+   some group is missing altogether. Then the least balanced cluster, and
+   the total squared distance to centroids, the cost of fairness.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+type dist struct {
+	D2         float64
+	pointIndex int
+}
+
+func main() {
+	delta := flag.Float64("delta", 0.1, "how far a group's share of a cluster can stray from its overall share, as a fraction of it")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: fairkm [-delta d] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *delta < 0 {
+		log.Fatalf("delta %f can't be negative\n", *delta)
+	}
+	points, groups, names := readPoints(flag.Arg(0))
+	if k < 1 || k > len(points) {
+		log.Fatalf("k %d out of range for %d points\n", k, len(points))
+	}
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	centroids, labels := fairkmeans(k, points, groups, len(names), *delta)
+
+	overall := make([]float64, len(names))
+	for _, g := range groups {
+		overall[g]++
+	}
+	counts := make([][]int, k)
+	sizes := make([]int, k)
+	for c := range counts {
+		counts[c] = make([]int, len(names))
+	}
+	sse := 0.0
+	for i, l := range labels {
+		counts[l][groups[i]]++
+		sizes[l]++
+		sse += sqDist(points[i], centroids[l])
+	}
+
+	worst := 1.0
+	for c := range centroids {
+		parts := make([]string, len(names))
+		for g, name := range names {
+			parts[g] = fmt.Sprintf("%s %d", name, counts[c][g])
+		}
+		b := balance(counts[c], sizes[c], overall, float64(len(points)))
+		worst = math.Min(worst, b)
+		fmt.Fprintf(os.Stderr, "# cluster %d, %d points, %s, balance %.3f\n", c, sizes[c], strings.Join(parts, ", "), b)
+	}
+	fmt.Fprintf(os.Stderr, "# least balance %.3f, SSE %f\n", worst, sse)
+
+	for i, centroid := range centroids {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+	for c := range centroids {
+		for i, point := range points {
+			if labels[i] == c {
+				fmt.Printf("%f %f %d\n", point.x, point.y, c)
+			}
+		}
+	}
+}
+
+/*
+Smallest ratio, over groups, between a group's share of the cluster
+and its share of all n points, each ratio taken as at most 1.
+*/
+func balance(counts []int, size int, overall []float64, n float64) float64 {
+	if size == 0 {
+		return 0
+	}
+	b := 1.0
+	for g, count := range counts {
+		share := float64(count) / float64(size)
+		whole := overall[g] / n
+		if share == 0 {
+			return 0
+		}
+		b = math.Min(b, math.Min(share/whole, whole/share))
+	}
+	return b
+}
+
+func fairkmeans(k int, points []Point, groups []int, ngroups int, delta float64) ([]Point, []int) {
+
+	members := make([][]int, ngroups)
+	for i, g := range groups {
+		members[g] = append(members[g], i)
+	}
+
+	centroids := kMeansPPCentroids(k, points)
+	labels := make([]int, len(points))
+
+	for iterations := 0; iterations < 100; iterations++ {
+		sizes := make([]float64, k)
+		for _, point := range points {
+			sizes[nearest(point, centroids)]++
+		}
+
+		for _, group := range members {
+			share := float64(len(group)) / float64(len(points))
+			lo := make([]float64, k)
+			hi := make([]float64, k)
+			for c := range centroids {
+				lo[c] = math.Floor((1 - delta) * share * sizes[c])
+				hi[c] = math.Ceil((1 + delta) * share * sizes[c])
+			}
+
+			groupPoints := make([]Point, len(group))
+			for j, i := range group {
+				groupPoints[j] = points[i]
+			}
+			for j, l := range assignFlow(groupPoints, centroids, lo, hi) {
+				labels[group[j]] = l
+			}
+		}
+
+		newcentroids := calcCentroids(k, points, labels, centroids)
+		looping := compareCentroids(centroids, newcentroids)
+		centroids = newcentroids
+		if !looping {
+			break
+		}
+	}
+
+	return centroids, labels
+}
+
+/*
+Minimum cost assignment of points to clusters, cluster c getting
+between lo[c] and hi[c] points, by successive shortest paths, the
+same as km3's assignFlow but with bounds per cluster and every point
+counting 1. Lower bounds get filled first by giving them a big
+negative cost. Shortest paths only need the clusters as nodes: entering
+cluster c costs the nearest unassigned point's distance to it, moving
+from c to c' costs the least d(i,c') - d(i,c) over points i in c.
+Returns every point's cluster.
+*/
+func assignFlow(points []Point, centroids []Point, lo, hi []float64) []int {
+	n, k := len(points), len(centroids)
+
+	d := make([][]float64, n)
+	maxd := 0.0
+	for i := range points {
+		d[i] = make([]float64, k)
+		for c := range centroids {
+			d[i][c] = sqDist(points[i], centroids[c])
+			maxd = math.Max(maxd, d[i][c])
+		}
+	}
+	big := 2*float64(n)*maxd + 1
+
+	labels := make([]int, n)
+	for i := range labels {
+		labels[i] = -1
+	}
+	load := make([]float64, k)
+
+	cost := make([]float64, k)
+	from := make([]int, k)
+	via := make([]int, k)
+	move := make([][]float64, k)
+	moveVia := make([][]int, k)
+	for c := range move {
+		move[c] = make([]float64, k)
+		moveVia[c] = make([]int, k)
+	}
+
+	for assigned := 0; assigned < n; assigned++ {
+		for c := range cost {
+			cost[c] = math.Inf(1)
+			from[c] = -1
+			for c2 := range move[c] {
+				move[c][c2] = math.Inf(1)
+			}
+		}
+		for i, l := range labels {
+			if l < 0 {
+				for c := range centroids {
+					if d[i][c] < cost[c] {
+						cost[c] = d[i][c]
+						via[c] = i
+					}
+				}
+				continue
+			}
+			for c2 := range centroids {
+				if m := d[i][c2] - d[i][l]; c2 != l && m < move[l][c2] {
+					move[l][c2] = m
+					moveVia[l][c2] = i
+				}
+			}
+		}
+
+		for round := 0; round < k; round++ {
+			changed := false
+			for c := range cost {
+				if math.IsInf(cost[c], 1) {
+					continue
+				}
+				for c2 := range cost {
+					if nd := cost[c] + move[c][c2]; nd < cost[c2]-1e-9*math.Max(1, math.Abs(cost[c2])) {
+						cost[c2] = nd
+						from[c2] = c
+						via[c2] = moveVia[c][c2]
+						changed = true
+					}
+				}
+			}
+			if !changed {
+				break
+			}
+		}
+
+		end := -1
+		best := math.Inf(1)
+		for c := range cost {
+			var endCost float64
+			switch {
+			case load[c] < lo[c]:
+				endCost = -big
+			case load[c] < hi[c]:
+				endCost = 0
+			default:
+				continue
+			}
+			if cost[c]+endCost < best {
+				best = cost[c] + endCost
+				end = c
+			}
+		}
+		if end < 0 {
+			log.Fatalf("no room left in any cluster with %d points still to assign\n", n-assigned)
+		}
+
+		load[end]++
+		c := end
+		for steps := 0; from[c] >= 0; steps++ {
+			if steps > k {
+				log.Fatal("cycle in shortest path tree")
+			}
+			labels[via[c]] = c
+			c = from[c]
+		}
+		labels[via[c]] = c
+	}
+
+	return labels
+}
+
+func nearest(point Point, centroids []Point) int {
+	min := math.Inf(1)
+	cent := 0
+	for c, centroid := range centroids {
+		if d := sqDist(point, centroid); d < min {
+			min = d
+			cent = c
+		}
+	}
+	return cent
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+func compareCentroids(centroids []Point, newcentroids []Point) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		dx := centroids[i].x - newcentroids[i].x
+		dy := centroids[i].y - newcentroids[i].y
+		dist := dx*dx + dy*dy
+		if dist > 0.01 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+// An empty cluster keeps its old centroid.
+func calcCentroids(k int, points []Point, labels []int, old []Point) []Point {
+	centroids := make([]Point, k)
+	counts := make([]float64, k)
+
+	for i, point := range points {
+		centroids[labels[i]].x += point.x
+		centroids[labels[i]].y += point.y
+		counts[labels[i]]++
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			centroids[c] = old[c]
+			continue
+		}
+		centroids[c].x /= counts[c]
+		centroids[c].y /= counts[c]
+	}
+
+	return centroids
+}
+
+/*
+Read "x y group" lines, skipping blank lines and '#' comments.
+Returns the points, each point's group as an index into the sorted
+group names, and the names.
+*/
+func readPoints(filename string) ([]Point, []int, []string) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+	var groupNames []string
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			log.Fatalf("%s line %d: %d fields, wanted \"x y group\"\n", filename, lineNo, len(fields))
+		}
+		x, errx := strconv.ParseFloat(fields[0], 64)
+		y, erry := strconv.ParseFloat(fields[1], 64)
+		if errx != nil || erry != nil {
+			log.Fatalf("%s line %d: bad coordinates\n", filename, lineNo)
+		}
+		points = append(points, Point{x: x, y: y})
+		groupNames = append(groupNames, fields[2])
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	index := make(map[string]int)
+	var names []string
+	for _, name := range groupNames {
+		if _, ok := index[name]; !ok {
+			index[name] = 0
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for g, name := range names {
+		index[name] = g
+	}
+	groups := make([]int, len(points))
+	for i, name := range groupNames {
+		groups[i] = index[name]
+	}
+
+	return points, groups, names
+}
+
+/*
+k-means++ method of finding initial guesses at centroids.
+
+ 1. Choose one center uniformly at random among the data points.
+ 2. For each data point x, compute D(x), the distance between x and the nearest
+    center that has already been chosen.
+ 3. Choose one new data point at random as a new center, using a weighted
+    probability distribution where a point x is chosen with probability
+    proportional to D(x)^2.
+ 4. Repeat Steps 2 and 3 until k centers have been chosen.
+*/
+func kMeansPPCentroids(k int, points []Point) (centroids []Point) {
+
+	centroids = append(centroids, points[rand.Intn(len(points))])
+
+	D := make([]dist, len(points))
+
+	for i := 0; i < k-1; i++ {
+		fillDistances(D, points, centroids)
+		newCenterIndex := weightedChoice(D)
+		centroids = append(centroids, points[newCenterIndex])
+	}
+
+	return
+}
+
+type interval struct {
+	maxval float64
+	index  int
+}
+
+/*
+Choose one new data point at random as a new center, using a weighted
+probability distribution where a point x is chosen with probability
+proportional to D(x)^2.
+*/
+func weightedChoice(D []dist) int {
+	sumValues := 0.0
+
+	var intervals []interval
+
+	for i := range D {
+		sumValues += D[i].D2
+		intervals = append(intervals, interval{maxval: sumValues, index: D[i].pointIndex})
+	}
+
+	inInterval := rand.Float64() * sumValues
+
+	for i := range intervals {
+		if inInterval < intervals[i].maxval {
+			return intervals[i].index
+		}
+	}
+
+	return 0
+}
+
+/*
+For each data point x, compute D(x)^2, the squared distance between x
+and the nearest center that has already been chosen.
+*/
+func fillDistances(D []dist, points []Point, centroids []Point) {
+
+	for idx, point := range points {
+		minD := sqDist(point, centroids[0])
+
+		for _, center := range centroids {
+			if d := sqDist(point, center); d < minD {
+				minD = d
+			}
+		}
+
+		D[idx].D2 = minD
+		D[idx].pointIndex = idx
+	}
+}
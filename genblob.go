@@ -4,10 +4,18 @@ package main
  * genblob - write text coordinates of circular "blobs"
  * or clusters of points on stdout.
  *
- * Usage: genblob $clusters $total_points
+ * Usage: genblob [-l] [-m metafile] $clusters $total_points
+ *
+ * With -l, every line gets a third column, the index of the blob
+ * the point came from, as ground truth for evaluate.
+ *
+ * With -m, the true blob centers also go to metafile as JSON,
+ * for recovery to compare against the centroids km1 finds.
  */
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"math"
@@ -17,23 +25,52 @@ import (
 	"time"
 )
 
+// Blobs is the metadata genblob writes with -m.
+type Blobs struct {
+	Blobs   int      `json:"blobs"`
+	Points  int      `json:"points"`
+	Radius  float64  `json:"radius"`
+	Centers []Center `json:"centers"`
+}
+
+// Center of one blob, and how many points it got.
+type Center struct {
+	Blob   int     `json:"blob"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Points int     `json:"points"`
+}
+
 func main() {
-	max, err := strconv.Atoi(os.Args[1])
+	labels := flag.Bool("l", false, "write each point's blob index as a third column")
+	metaFile := flag.String("m", "", "write blob centers to this file as JSON")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		log.Fatal("usage: genblob [-l] [-m metafile] clusters total_points")
+	}
+
+	max, err := strconv.Atoi(args[0])
 	if err != nil {
 		log.Fatal(err)
 	}
-	N, err := strconv.Atoi(os.Args[2])
+	N, err := strconv.Atoi(args[1])
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
 
+	const radius = 50.
+	meta := Blobs{Blobs: max, Points: max * (N / max), Radius: radius}
+
 	// max is number of "blobs" or clusters
 	for i := 0; i < max; i++ {
 		x0 := 100. * rand.Float64()
 		y0 := 100. * rand.Float64()
 		fmt.Fprintf(os.Stderr, "# Blob %d X0,Y0 %f,%f\n", i, x0, y0)
+		meta.Centers = append(meta.Centers, Center{Blob: i, X: x0, Y: y0, Points: N / max})
 
 		// N/max count of points per blob.
 		// This will produce point-density higher the closter
@@ -41,12 +78,27 @@ func main() {
 		// has more area as radius increases, but the uniform
 		// distribution of rand.Float64 will put the same number
 		// of points on any given radius.
-		for i := 0; i < N/max; i++ {
+		for j := 0; j < N/max; j++ {
 			th := 6.28 * rand.Float64()
-			r := 50. * rand.Float64()
+			r := radius * rand.Float64()
 			x := x0 + r*math.Cos(th)
 			y := y0 + r*math.Sin(th)
-			fmt.Printf("%f %f\n", x, y)
+			if *labels {
+				fmt.Printf("%f %f %d\n", x, y, i)
+			} else {
+				fmt.Printf("%f %f\n", x, y)
+			}
+		}
+	}
+
+	if *metaFile != "" {
+		buf, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		buf = append(buf, '\n')
+		if err := os.WriteFile(*metaFile, buf, 0644); err != nil {
+			log.Fatal(err)
 		}
 	}
 }
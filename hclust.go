@@ -0,0 +1,496 @@
+package main
+
+/*
+   Agglomerative hierarchical clustering, as a baseline to compare with km1.
+
+   Usage: hclust [-linkage single|complete|average|ward] [-newick file] [-svg file] filename k
+
+   Every point starts out as its own cluster, and the two closest clusters
+   merge until only one is left. How "closest" gets measured is the linkage:
+
+   single   - distance between the nearest members of the two clusters
+   complete - distance between the farthest members
+   average  - mean distance over all pairs of members
+   ward     - increase in sum of squared distances to centroids on merging
+
+   Merging uses the nearest-neighbor chain algorithm, O(n^2) time instead
+   of the O(n^3) of re-scanning for the closest pair on every merge. All four
+   linkages are "reducible", so the chain finds the same merges the naive
+   way would. Ward's linkage works from cluster centroids and sizes, so it
+   only needs O(n) memory, the other three keep an n*(n-1)/2 distance matrix.
+
+   The merge history can be written as a Newick tree and an SVG dendrogram.
+   The tree gets cut at k clusters, and the result goes to stdout in the same
+   "x y label" format km1 uses, cluster means as "x y cN" lines, so do7 and
+   doblob style plotting still works.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+// merge records clusters a and b (identified by the index of a
+// representative point) joining at the given height.
+type merge struct {
+	a, b   int
+	height float64
+}
+
+// node of the dendrogram. Leaves 0..n-1 are points, internal
+// nodes n..2n-2 are merges, in order of increasing height.
+type node struct {
+	left, right int
+	height      float64
+	size        int
+}
+
+func main() {
+	linkage := flag.String("linkage", "average", "linkage, one of single, complete, average, ward")
+	newickFile := flag.String("newick", "", "write merge history as a Newick tree to this file")
+	svgFile := flag.String("svg", "", "write an SVG dendrogram to this file")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: hclust [-linkage single|complete|average|ward] [-newick file] [-svg file] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	points := readPoints(flag.Arg(0))
+	if k < 1 || k > len(points) {
+		log.Fatalf("k %d out of range for %d points\n", k, len(points))
+	}
+
+	var merges []merge
+	switch *linkage {
+	case "single", "complete", "average":
+		merges = nnChain(newMatrixLinkage(*linkage, points))
+	case "ward":
+		merges = nnChain(newWardLinkage(points))
+	default:
+		log.Fatalf("unknown linkage %q\n", *linkage)
+	}
+
+	// The nearest-neighbor chain finds merges out of height order.
+	// buildTree and cutTree both want them lowest first.
+	sort.SliceStable(merges, func(i, j int) bool { return merges[i].height < merges[j].height })
+
+	tree := buildTree(len(points), merges)
+
+	if *newickFile != "" {
+		writeFile(*newickFile, func(w io.Writer) { writeNewick(w, tree, len(points)) })
+	}
+	if *svgFile != "" {
+		writeFile(*svgFile, func(w io.Writer) { writeDendrogram(w, tree, len(points)) })
+	}
+
+	clusters := cutTree(points, merges, k)
+
+	for i, centroid := range calcCentroids(clusters) {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+
+	for i, cluster := range clusters {
+		for _, point := range cluster {
+			fmt.Printf("%f %f %d\n", point.x, point.y, i)
+		}
+	}
+}
+
+// linkage measures and merges clusters, each identified by the index
+// of a representative point. Merging b into a leaves a as the
+// identifier of the merged cluster.
+type linkage interface {
+	size() int
+	distance(a, b int) float64
+	merge(a, b int)
+}
+
+/*
+Nearest-neighbor chain: grow a chain of clusters, each the nearest
+neighbor of the one before it, until the last two clusters on the chain
+are each other's nearest neighbors. Merge those two, and carry on from
+whatever's left of the chain.
+*/
+func nnChain(l linkage) []merge {
+	n := l.size()
+	active := make([]bool, n)
+	for i := range active {
+		active[i] = true
+	}
+
+	var merges []merge
+	var chain []int
+
+	for len(merges) < n-1 {
+		if len(chain) == 0 {
+			for i := range active {
+				if active[i] {
+					chain = append(chain, i)
+					break
+				}
+			}
+		}
+
+		a := chain[len(chain)-1]
+		prev := -1
+		if len(chain) > 1 {
+			prev = chain[len(chain)-2]
+		}
+
+		// Prefer the previous chain element on ties, or the chain can cycle.
+		b := prev
+		min := math.Inf(1)
+		if prev >= 0 {
+			min = l.distance(a, prev)
+		}
+		for c := range active {
+			if !active[c] || c == a || c == prev {
+				continue
+			}
+			if d := l.distance(a, c); d < min {
+				min = d
+				b = c
+			}
+		}
+
+		if b == prev {
+			chain = chain[:len(chain)-2]
+			l.merge(a, b)
+			active[b] = false
+			merges = append(merges, merge{a: a, b: b, height: min})
+			continue
+		}
+
+		chain = append(chain, b)
+	}
+
+	return merges
+}
+
+// Single, complete and average linkage, Lance-Williams updates
+// of a condensed matrix of Euclidean distances.
+type matrixLinkage struct {
+	n      int
+	method string
+	sizes  []int
+	d      []float64
+}
+
+func newMatrixLinkage(method string, points []Point) *matrixLinkage {
+	n := len(points)
+	m := &matrixLinkage{
+		n:      n,
+		method: method,
+		sizes:  make([]int, n),
+		d:      make([]float64, n*(n-1)/2),
+	}
+	for i := range points {
+		m.sizes[i] = 1
+		for j := i + 1; j < n; j++ {
+			dx := points[i].x - points[j].x
+			dy := points[i].y - points[j].y
+			m.d[m.index(i, j)] = math.Sqrt(dx*dx + dy*dy)
+		}
+	}
+	return m
+}
+
+func (m *matrixLinkage) index(i, j int) int {
+	if i > j {
+		i, j = j, i
+	}
+	return m.n*i - i*(i+1)/2 + j - i - 1
+}
+
+func (m *matrixLinkage) size() int { return m.n }
+
+func (m *matrixLinkage) distance(a, b int) float64 { return m.d[m.index(a, b)] }
+
+func (m *matrixLinkage) merge(a, b int) {
+	na, nb := float64(m.sizes[a]), float64(m.sizes[b])
+	for c := 0; c < m.n; c++ {
+		if c == a || c == b || m.sizes[c] == 0 {
+			continue
+		}
+		dac, dbc := m.distance(a, c), m.distance(b, c)
+		var d float64
+		switch m.method {
+		case "single":
+			d = math.Min(dac, dbc)
+		case "complete":
+			d = math.Max(dac, dbc)
+		case "average":
+			d = (na*dac + nb*dbc) / (na + nb)
+		}
+		m.d[m.index(a, c)] = d
+	}
+	m.sizes[a] += m.sizes[b]
+	m.sizes[b] = 0
+}
+
+/*
+Ward's linkage from centroids and sizes. The distance is
+sqrt(2*na*nb/(na+nb)) * |ca - cb|, so that merge heights match
+what the Lance-Williams formulation of Ward's method gives.
+*/
+type wardLinkage struct {
+	centroids []Point
+	sizes     []float64
+}
+
+func newWardLinkage(points []Point) *wardLinkage {
+	w := &wardLinkage{
+		centroids: append([]Point(nil), points...),
+		sizes:     make([]float64, len(points)),
+	}
+	for i := range w.sizes {
+		w.sizes[i] = 1
+	}
+	return w
+}
+
+func (w *wardLinkage) size() int { return len(w.centroids) }
+
+func (w *wardLinkage) distance(a, b int) float64 {
+	na, nb := w.sizes[a], w.sizes[b]
+	dx := w.centroids[a].x - w.centroids[b].x
+	dy := w.centroids[a].y - w.centroids[b].y
+	return math.Sqrt(2 * na * nb / (na + nb) * (dx*dx + dy*dy))
+}
+
+func (w *wardLinkage) merge(a, b int) {
+	na, nb := w.sizes[a], w.sizes[b]
+	w.centroids[a] = Point{
+		x: (na*w.centroids[a].x + nb*w.centroids[b].x) / (na + nb),
+		y: (na*w.centroids[a].y + nb*w.centroids[b].y) / (na + nb),
+	}
+	w.sizes[a] += nb
+	w.sizes[b] = 0
+}
+
+/*
+Use union-find to turn merges, sorted by height, into
+a dendrogram, with node n+i the i-th merge.
+*/
+func buildTree(n int, merges []merge) []node {
+	tree := make([]node, n, 2*n-1)
+	for i := range tree {
+		tree[i] = node{left: -1, right: -1, size: 1}
+	}
+
+	parent := make([]int, n)
+	nodeOf := make([]int, n) // tree node of each union-find root
+	for i := range parent {
+		parent[i] = i
+		nodeOf[i] = i
+	}
+
+	for _, m := range merges {
+		ra, rb := find(parent, m.a), find(parent, m.b)
+		left, right := nodeOf[ra], nodeOf[rb]
+		tree = append(tree, node{
+			left:   left,
+			right:  right,
+			height: m.height,
+			size:   tree[left].size + tree[right].size,
+		})
+		parent[rb] = ra
+		nodeOf[ra] = len(tree) - 1
+	}
+
+	return tree
+}
+
+func find(parent []int, i int) int {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+/*
+Apply the n-k lowest merges, the first n-k of merges sorted
+by height, to get k clusters. Clusters get numbered
+in order of their lowest-indexed point.
+*/
+func cutTree(points []Point, merges []merge, k int) [][]Point {
+	n := len(points)
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	for _, m := range merges[:n-k] {
+		parent[find(parent, m.b)] = find(parent, m.a)
+	}
+
+	label := make(map[int]int)
+	clusters := make([][]Point, k)
+	for i, point := range points {
+		root := find(parent, i)
+		l, ok := label[root]
+		if !ok {
+			l = len(label)
+			label[root] = l
+		}
+		clusters[l] = append(clusters[l], point)
+	}
+
+	return clusters
+}
+
+/*
+Newick format, leaves named p0, p1, ... by their line in the input file.
+Branch lengths are the difference in height between a node and its parent.
+*/
+func writeNewick(w io.Writer, tree []node, n int) {
+	var walk func(i int, parentHeight float64)
+	walk = func(i int, parentHeight float64) {
+		nd := tree[i]
+		if i < n {
+			fmt.Fprintf(w, "p%d:%f", i, parentHeight)
+			return
+		}
+		fmt.Fprint(w, "(")
+		walk(nd.left, nd.height)
+		fmt.Fprint(w, ",")
+		walk(nd.right, nd.height)
+		fmt.Fprintf(w, "):%f", parentHeight-nd.height)
+	}
+
+	root := len(tree) - 1
+	if root < n {
+		fmt.Fprintf(w, "p%d;\n", root)
+		return
+	}
+	nd := tree[root]
+	fmt.Fprint(w, "(")
+	walk(nd.left, nd.height)
+	fmt.Fprint(w, ",")
+	walk(nd.right, nd.height)
+	fmt.Fprint(w, ");\n")
+}
+
+/*
+SVG dendrogram: leaves spread along the bottom in tree order,
+merge height going up the page.
+*/
+func writeDendrogram(w io.Writer, tree []node, n int) {
+	const width, height, margin = 1000.0, 600.0, 20.0
+
+	root := len(tree) - 1
+	maxHeight := tree[root].height
+	if maxHeight == 0 {
+		maxHeight = 1
+	}
+
+	// x position of every node, leaves first in depth-first order
+	xpos := make([]float64, len(tree))
+	spacing := (width - 2*margin) / float64(n)
+	leaf := 0
+	var place func(i int)
+	place = func(i int) {
+		if i < n {
+			xpos[i] = margin + spacing*(float64(leaf)+0.5)
+			leaf++
+			return
+		}
+		place(tree[i].left)
+		place(tree[i].right)
+		xpos[i] = (xpos[tree[i].left] + xpos[tree[i].right]) / 2
+	}
+	place(root)
+
+	ypos := func(h float64) float64 {
+		return height - margin - h/maxHeight*(height-2*margin)
+	}
+
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%.0f\" height=\"%.0f\">\n", width, height)
+	fmt.Fprintf(w, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+	fmt.Fprintf(w, "<g stroke=\"black\" stroke-width=\"1\" fill=\"none\">\n")
+	for i := n; i < len(tree); i++ {
+		nd := tree[i]
+		y := ypos(nd.height)
+		xl, xr := xpos[nd.left], xpos[nd.right]
+		fmt.Fprintf(w, "<path d=\"M%.2f %.2f V%.2f H%.2f V%.2f\"/>\n",
+			xl, ypos(tree[nd.left].height), y, xr, ypos(tree[nd.right].height))
+	}
+	fmt.Fprintf(w, "</g>\n</svg>\n")
+}
+
+func writeFile(filename string, write func(io.Writer)) {
+	fout, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bw := bufio.NewWriter(fout)
+	write(bw)
+	if err := bw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := fout.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func calcCentroids(clusters [][]Point) []Point {
+	centroids := make([]Point, len(clusters))
+
+	for cent, cluster := range clusters {
+		var sumx, sumy float64
+		for _, point := range cluster {
+			sumx += point.x
+			sumy += point.y
+		}
+		centroids[cent] = Point{x: sumx / float64(len(cluster)), y: sumy / float64(len(cluster))}
+	}
+
+	return centroids
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
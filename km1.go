@@ -1,13 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,36 +21,143 @@ type Point struct {
 	y float64
 }
 
+// Model is what a trained clustering saves, so that predict can
+// label new points without re-clustering.
+type Model struct {
+	Dimension int         `json:"dimension"`
+	Metric    string      `json:"metric"`
+	Scaling   Scaling     `json:"scaling"`
+	Centroids [][]float64 `json:"centroids"`
+	Training  Training    `json:"training"`
+}
+
+// Scaling maps a raw coordinate v to (v - Center)/Scale,
+// per dimension, before measuring distance to centroids.
+type Scaling struct {
+	Method string    `json:"method"`
+	Center []float64 `json:"center"`
+	Scale  []float64 `json:"scale"`
+}
+
+// Training metadata, for people reading the model file.
+type Training struct {
+	File       string    `json:"file"`
+	Points     int       `json:"points"`
+	K          int       `json:"k"`
+	Iterations int       `json:"iterations"`
+	SSE        float64   `json:"sse"`
+	Sizes      []int     `json:"sizes"`
+	Trained    time.Time `json:"trained"`
+}
+
 func main() {
-	k, err := strconv.Atoi(os.Args[2])
+	modelFile := flag.String("model", "", "save the trained model to this file")
+	method := flag.String("scale", "none", "feature scaling, none, zscore, minmax or robust")
+	weights := flag.String("weights", "", "per-column weights, like 1,2, applied after scaling")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: km1 [-model file] [-scale none|zscore|minmax|robust] [-weights wx,wy] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
 	if err != nil {
 		log.Fatal(err)
 	}
-	filename := os.Args[1]
+	filename := flag.Arg(0)
 	points := readPoints(filename)
 
 	ps := PointSlice(points)
 	sort.Sort(ps)
 
+	scaling := fitScaling(*method, points)
+	if *weights != "" {
+		scaling.weight(*weights)
+	}
+
 	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
 
-	kmeanscluster(k, points)
+	// Cluster in scaled units, print in original units.
+	scaled := make([]Point, len(points))
+	for i, point := range points {
+		scaled[i] = scaling.scale(point)
+	}
+
+	centroids, labels, iterations := kmeanscluster(k, scaled, scaling)
+
+	for i, centroid := range centroids {
+		centroid = scaling.unscale(centroid)
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+
+	for c := range centroids {
+		for i, point := range points {
+			if labels[i] == c {
+				fmt.Printf("%f %f %d\n", point.x, point.y, c)
+			}
+		}
+	}
+
+	if *modelFile != "" {
+		model := Model{
+			Dimension: 2,
+			Metric:    "euclidean",
+			Scaling:   scaling,
+			Training: Training{
+				File:       filename,
+				Points:     len(points),
+				K:          k,
+				Iterations: iterations,
+				Trained:    time.Now().UTC(),
+			},
+		}
+		model.Training.Sizes = make([]int, k)
+		for _, centroid := range centroids {
+			model.Centroids = append(model.Centroids, []float64{centroid.x, centroid.y})
+		}
+		for i, point := range scaled {
+			centroid := centroids[labels[i]]
+			dx := centroid.x - point.x
+			dy := centroid.y - point.y
+			model.Training.SSE += dx*dx + dy*dy
+			model.Training.Sizes[labels[i]]++
+		}
+		writeModel(*modelFile, model)
+	}
+}
+
+func writeModel(filename string, model Model) {
+	buf, err := json.MarshalIndent(model, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	buf = append(buf, '\n')
+	if err := os.WriteFile(filename, buf, 0644); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func kmeanscluster(k int, points []Point) {
+/*
+Lloyd's algorithm on scaled points. Centroids count as settled
+once they stop moving in original units, whatever the scaling.
+Returns the centroids, each point's cluster, and the iterations.
+*/
+func kmeanscluster(k int, points []Point, scaling Scaling) ([]Point, []int, int) {
 
 	centroids := randomCentroids(k, points)
 
 	looping := true
 
-	var finalclusters [][]Point
+	labels := make([]int, len(points))
+	iterations := 0
 
 	for looping {
 		// fmt.Printf("Centroids: %+v\n", centroids)
+		iterations++
 
 		clusters := make([][]Point, k)
 
-		for _, point := range points {
+		for p, point := range points {
 			distances := make([]float64, k)
 			for i := 0; i < k; i++ {
 				centroid := centroids[i]
@@ -63,23 +174,124 @@ func kmeanscluster(k int, points []Point) {
 				}
 			}
 			clusters[cent] = append(clusters[cent], point)
+			labels[p] = cent
 		}
 
 		newcentroids := calcCentroids(clusters)
-		looping = compareCentroids(centroids, newcentroids)
+		looping = compareCentroids(scaling.unscaleAll(centroids), scaling.unscaleAll(newcentroids))
 		centroids = newcentroids
-		finalclusters = clusters
 	}
 
-	for i, centroid := range centroids {
-		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	return centroids, labels, iterations
+}
+
+/*
+Feature scaling, fit to the points:
+none   - leave coordinates alone
+zscore - subtract the mean, divide by the standard deviation
+minmax - subtract the minimum, divide by the range, so 0 to 1
+robust - subtract the median, divide by the interquartile range,
+
+	so a few outliers don't squash everything else
+
+A column with no spread at all gets scale 1.
+*/
+func fitScaling(method string, points []Point) Scaling {
+	scaling := Scaling{Method: method, Center: []float64{0, 0}, Scale: []float64{1, 1}}
+	if len(points) == 0 {
+		return scaling
 	}
 
-	for i, cluster := range finalclusters {
-		for _, point := range cluster {
-			fmt.Printf("%f %f %d\n", point.x, point.y, i)
+	for j, column := range columns(points) {
+		sort.Float64s(column)
+		n := float64(len(column))
+		switch method {
+		case "none":
+		case "zscore":
+			var sum, sumsq float64
+			for _, v := range column {
+				sum += v
+			}
+			mean := sum / n
+			for _, v := range column {
+				sumsq += (v - mean) * (v - mean)
+			}
+			scaling.Center[j] = mean
+			scaling.Scale[j] = math.Sqrt(sumsq / n)
+		case "minmax":
+			scaling.Center[j] = column[0]
+			scaling.Scale[j] = column[len(column)-1] - column[0]
+		case "robust":
+			scaling.Center[j] = quantile(column, 0.5)
+			scaling.Scale[j] = quantile(column, 0.75) - quantile(column, 0.25)
+		default:
+			log.Fatalf("unknown -scale %q, want none, zscore, minmax or robust\n", method)
 		}
+		if scaling.Scale[j] == 0 {
+			scaling.Scale[j] = 1
+		}
+	}
+
+	return scaling
+}
+
+func columns(points []Point) [][]float64 {
+	xs := make([]float64, len(points))
+	ys := make([]float64, len(points))
+	for i, point := range points {
+		xs[i] = point.x
+		ys[i] = point.y
+	}
+	return [][]float64{xs, ys}
+}
+
+// quantile q of sorted values, interpolating between neighbors.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (pos-float64(lo))*(sorted[hi]-sorted[lo])
+}
+
+/*
+Multiply scaled columns by comma-separated weights, so a column with
+weight 2 counts twice as much in distances. Folded into Scale, which
+keeps the model file's (v - Center)/Scale mapping good for predict.
+*/
+func (s *Scaling) weight(weights string) {
+	fields := strings.Split(weights, ",")
+	if len(fields) != len(s.Scale) {
+		log.Fatalf("-weights %q: want %d weights\n", weights, len(s.Scale))
+	}
+	for j, field := range fields {
+		w, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || w <= 0 {
+			log.Fatalf("-weights %q: bad weight %q\n", weights, field)
+		}
+		s.Scale[j] /= w
+	}
+}
+
+func (s Scaling) scale(p Point) Point {
+	return Point{
+		x: (p.x - s.Center[0]) / s.Scale[0],
+		y: (p.y - s.Center[1]) / s.Scale[1],
+	}
+}
+
+func (s Scaling) unscale(p Point) Point {
+	return Point{
+		x: p.x*s.Scale[0] + s.Center[0],
+		y: p.y*s.Scale[1] + s.Center[1],
+	}
+}
+
+func (s Scaling) unscaleAll(points []Point) []Point {
+	raw := make([]Point, len(points))
+	for i, p := range points {
+		raw[i] = s.unscale(p)
 	}
+	return raw
 }
 
 func compareCentroids(centroids []Point, newcentroids []Point) bool {
@@ -1,18 +1,51 @@
 package main
 
 /*
-   K-means clustering with k-means++ initial centroid choice.
+   K-means clustering with bounds on cluster sizes.
+
+   Usage: km3 [-pop] [-balance] [-min m] [-max m] filename k
+
+   Reads "pop x y" lines, as from genrand -p, or plain "x y" lines,
+   which count as population 1.
+
+   Plain k-means can make clusters of any size. km3 keeps every cluster
+   between -min and -max points or, with -pop, between -min and -max total
+   population, like "every region between 8000 and 12000 residents".
+   -balance sets both bounds to an equal share, n/k points, or total
+   population over k.
+
+   Initial centroids come from k-means++. The assignment step is a
+   minimum cost flow problem: every point supplies its weight, 1 or its
+   population, each unit of a point's weight sent to a cluster costs the
+   squared distance to that cluster's centroid, and each cluster takes in
+   at least min and at most max. Solving that exactly, by successive
+   shortest paths, is what a greedy fill, assigning points to their
+   preferred cluster until it's full, can't guarantee. The update step
+   moves each centroid to the weighted mean of what flowed to it.
+
+   With -pop, the flow can split one point's population between clusters.
+   Points are indivisible, so a split point goes to the cluster that got
+   most of it, and if that pushes clusters outside their bounds, points
+   move between clusters, as cheaply as they can, to bring them back.
+   Only when no move helps can a cluster end up outside its bounds. Points with
+   population 0 go to their nearest centroid. Split points and bound
+   violations go to stderr, along with every cluster's size and population.
+
+   Output is km1's format.
 */
 
 import (
+	"bufio"
+	"container/heap"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"math/rand"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,104 +62,424 @@ type dist struct {
 }
 
 func main() {
-	k, err := strconv.Atoi(os.Args[2])
+	byPop := flag.Bool("pop", false, "bound total population per cluster instead of point counts")
+	balance := flag.Bool("balance", false, "bound every cluster to an equal share")
+	min := flag.Float64("min", 0, "smallest cluster, in points, or population with -pop")
+	max := flag.Float64("max", 0, "largest cluster, in points, or population with -pop, 0 for no limit")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: km3 [-pop] [-balance] [-min m] [-max m] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
 	if err != nil {
 		log.Fatal(err)
 	}
-	filename := os.Args[1]
+	filename := flag.Arg(0)
 	points := readPoints(filename)
+	if k < 1 || k > len(points) {
+		log.Fatalf("k %d out of range for %d points\n", k, len(points))
+	}
 
 	ps := PointSlice(points)
 	sort.Sort(ps)
 
+	weights := make([]float64, len(points))
+	total := 0.0
 	for i := range points {
-		fmt.Printf("%4d  %.0f  %f %f\n", i, points[i].pop, points[i].x, points[i].y)
+		weights[i] = 1
+		if *byPop {
+			weights[i] = points[i].pop
+		}
+		total += weights[i]
+	}
+
+	lo, hi := *min, *max
+	if hi <= 0 {
+		hi = total
+	}
+	if *balance {
+		lo, hi = total/float64(k), total/float64(k)
+		if !*byPop {
+			lo, hi = math.Floor(lo), math.Ceil(hi)
+		}
+	}
+	if lo > hi {
+		log.Fatalf("min %f more than max %f\n", lo, hi)
+	}
+	if float64(k)*lo > total*(1+1e-9) {
+		log.Fatalf("infeasible: %d clusters of at least %f need %f, only %f in all\n", k, lo, float64(k)*lo, total)
+	}
+	if float64(k)*hi < total*(1-1e-9) {
+		log.Fatalf("infeasible: %d clusters of at most %f hold %f, but there's %f in all\n", k, hi, float64(k)*hi, total)
 	}
 
 	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
 
-	kmeanscluster(k, points)
+	centroids, labels, split := kmeanscluster(k, points, weights, lo, hi)
+
+	sizes := make([]int, k)
+	loads := make([]float64, k)
+	for i, l := range labels {
+		sizes[l]++
+		loads[l] += weights[i]
+	}
+	unit := "points"
+	if *byPop {
+		unit = "population"
+	}
+	fmt.Fprintf(os.Stderr, "# bounds %f to %f %s per cluster\n", lo, hi, unit)
+	for c := range centroids {
+		pop := 0.0
+		for i, l := range labels {
+			if l == c {
+				pop += points[i].pop
+			}
+		}
+		fmt.Fprintf(os.Stderr, "# cluster %d, %d points, population %.0f\n", c, sizes[c], pop)
+		if loads[c] < lo-1e-9*total || loads[c] > hi+1e-9*total {
+			fmt.Fprintf(os.Stderr, "# cluster %d has %f %s, outside bounds after rounding split points\n", c, loads[c], unit)
+		}
+	}
+	if split > 0 {
+		fmt.Fprintf(os.Stderr, "# %d points split between clusters by the flow, each went to its biggest share\n", split)
+	}
+
+	for i, centroid := range centroids {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+	for c := range centroids {
+		for i, point := range points {
+			if labels[i] == c {
+				fmt.Printf("%f %f %d\n", point.x, point.y, c)
+			}
+		}
+	}
 }
 
-func kmeanscluster(k int, points []Point) {
+/*
+Alternate a minimum cost flow assignment with moving centroids
+to the weighted mean of their flow. Returns the centroids, each
+point's cluster, and how many points the last flow split.
+*/
+func kmeanscluster(k int, points []Point, weights []float64, lo, hi float64) ([]Point, []int, int) {
 
-	/*
-	   Initialization:
-	   1. Compute the desired cluster size, n/k.
-	   2. Initialize means, preferably with k-means++
-	   3. Order points by the distance to their nearest cluster minus distance to
-	      the farthest cluster (= biggest benefit of best over worst assignment)
-	   4. Assign points to their preferred cluster until this cluster is full, then
-	      resort remaining objects, without taking the full cluster into account
-	      anymore
+	centroids := kMeansPPCentroids(k, points)
 
-	*/
+	var flow [][]float64
 
-	// clusterSize := len(points) / k
+	for iterations := 0; iterations < 100; iterations++ {
+		flow = assignFlow(points, weights, centroids, lo, hi)
 
-	centroids := kMeansPPCentroids(k, points)
-	fmt.Printf("Final centroids %d:\n", len(centroids))
-	for i := range centroids {
-		fmt.Printf("centroid %d (%f, %f)\n", i, centroids[i].x, centroids[i].y)
+		newcentroids := calcCentroids(points, flow, centroids)
+		looping := compareCentroids(centroids, newcentroids)
+		centroids = newcentroids
+		if !looping {
+			break
+		}
 	}
 
-	orderedPoints := orderPointsByDistance(points, centroids)
-	fmt.Printf("%d orderedPoints\n", len(orderedPoints))
-	for i := range orderedPoints {
-		pt := points[orderedPoints[i].pointIndex]
+	labels, split := roundFlow(points, weights, flow, centroids, lo, hi)
+
+	return centroids, labels, split
+}
 
-		fmt.Printf("  ordered point %d, dist diff %f, point %d (%f, %f), %v\n",
-			i,
-			orderedPoints[i].distDiff,
-			orderedPoints[i].pointIndex,
-			pt.x, pt.y, orderedPoints[i].distances,
-		)
+/*
+Give every point a single cluster. A point the flow sent all to one
+cluster goes there, a split point to its biggest share, and a point
+with no weight, which the flow never touches, to its nearest centroid.
+Rounding split points can push clusters past their bounds, so then
+move whole points, each time making the move that costs the least
+squared distance among those that bring loads closer to within lo
+and hi, until loads are within bounds or no move helps.
+Returns the labels and how many points the flow split.
+*/
+func roundFlow(points []Point, weights []float64, flow [][]float64, centroids []Point, lo, hi float64) ([]int, int) {
+	labels := make([]int, len(points))
+	load := make([]float64, len(centroids))
+	split := 0
+	for i := range points {
+		labels[i] = -1
+		shares := 0
+		for c := range centroids {
+			if flow[i][c] > 0 {
+				shares++
+				if labels[i] < 0 || flow[i][c] > flow[i][labels[i]] {
+					labels[i] = c
+				}
+			}
+		}
+		if labels[i] < 0 {
+			labels[i] = nearest(points[i], centroids)
+		}
+		if shares > 1 {
+			split++
+		}
+		load[labels[i]] += weights[i]
 	}
 
-	assignToClusters(orderedPoints, k)
+	// How far a load is outside the bounds.
+	outside := func(l float64) float64 {
+		return math.Max(0, lo-l) + math.Max(0, l-hi)
+	}
+	tolerance := 1e-9 * math.Max(1, hi)
 
-	looping := true
+	for {
+		bestI, bestC := -1, -1
+		bestCost := math.Inf(1)
+		for i, point := range points {
+			from, w := labels[i], weights[i]
+			if w == 0 {
+				continue
+			}
+			for c, centroid := range centroids {
+				if c == from {
+					continue
+				}
+				before := outside(load[from]) + outside(load[c])
+				after := outside(load[from]-w) + outside(load[c]+w)
+				if after >= before-tolerance {
+					continue
+				}
+				if cost := w * (sqDist(point, centroid) - sqDist(point, centroids[from])); cost < bestCost {
+					bestI, bestC, bestCost = i, c, cost
+				}
+			}
+		}
+		if bestI < 0 {
+			break
+		}
+		load[labels[bestI]] -= weights[bestI]
+		load[bestC] += weights[bestI]
+		labels[bestI] = bestC
+	}
 
-	var finalclusters [][]Point
+	return labels, split
+}
 
-	for looping {
+func nearest(p Point, centroids []Point) int {
+	min := math.Inf(1)
+	best := 0
+	for c, centroid := range centroids {
+		if d := sqDist(p, centroid); d < min {
+			min = d
+			best = c
+		}
+	}
+	return best
+}
 
-		clusters := make([][]Point, k)
+/*
+Minimum cost flow from points to clusters, by successive shortest
+paths. Point i supplies weights[i], sending a unit of it to cluster c
+costs the squared distance from point i to centroid c, and every
+cluster takes in between lo and hi. Returns flow[i][c].
+
+Lower bounds work by giving a cluster's first lo units a big negative
+cost, so shortest paths fill every cluster to lo before anything else.
+
+Every augmenting path starts at a point with supply left, goes to some
+cluster, maybe moves flow from cluster to cluster by re-routing points
+already there, and ends at a cluster with room. So shortest paths only
+need the k clusters as nodes: the cost of entering cluster c is the
+cheapest remaining point's distance to c, and the cost of moving from
+c to c' is the cheapest d(i,c') - d(i,c) over points i with flow in c.
+Bellman-Ford on that handles the negative costs.
+
+Those cheapest points come off heaps, one per cluster for entering and
+one per pair of clusters for moving, so a path costs O(k^2 log n + k^3)
+to find instead of a pass over every point. Distances don't change
+within one assignment, so heap entries never need updating, just
+dropping once their point has no supply left, or no flow left to move.
+Each path carries as much as it can: all of the point's remaining
+supply, unless room at the end or flow along the way runs out first.
+*/
+func assignFlow(points []Point, weights []float64, centroids []Point, lo, hi float64) [][]float64 {
+	n, k := len(points), len(centroids)
+
+	d := make([][]float64, n)
+	flow := make([][]float64, n)
+	remaining := make([]float64, n)
+	maxd := 0.0
+	total := 0.0
+	for i := range points {
+		d[i] = make([]float64, k)
+		flow[i] = make([]float64, k)
+		for c := range centroids {
+			d[i][c] = sqDist(points[i], centroids[c])
+			maxd = math.Max(maxd, d[i][c])
+		}
+		remaining[i] = weights[i]
+		total += weights[i]
+	}
+	big := 2*float64(n)*maxd + 1
+	eps := 1e-12 * math.Max(total, 1)
+	load := make([]float64, k)
+
+	// enter[c] holds points with supply left by d(i,c), move[c][c2]
+	// points with flow in c by d(i,c2) - d(i,c).
+	enter := make([]candidateHeap, k)
+	move := make([][]candidateHeap, k)
+	for c := range enter {
+		for i := range points {
+			if remaining[i] > eps {
+				enter[c] = append(enter[c], candidate{i: i, cost: d[i][c]})
+			}
+		}
+		heap.Init(&enter[c])
+		move[c] = make([]candidateHeap, k)
+	}
+	arrive := func(i, c int) {
+		if flow[i][c] > eps {
+			return // already on c's move heaps
+		}
+		for c2 := range move[c] {
+			if c2 != c {
+				heap.Push(&move[c][c2], candidate{i: i, cost: d[i][c2] - d[i][c]})
+			}
+		}
+	}
 
-		for _, point := range points {
-			distances := make([]float64, k)
-			for i := 0; i < k; i++ {
-				centroid := centroids[i]
-				dx := centroid.x - point.x
-				dy := centroid.y - point.y
-				distances[i] = dx*dx + dy*dy
+	// Cheapest way into each cluster, from a point (from < 0) or from
+	// another cluster, through which point.
+	cost := make([]float64, k)
+	from := make([]int, k)
+	via := make([]int, k)
+
+	for left := total; left > eps; {
+		for c := range cost {
+			cost[c] = math.Inf(1)
+			from[c] = -1
+			for len(enter[c]) > 0 && remaining[enter[c][0].i] <= eps {
+				heap.Pop(&enter[c])
+			}
+			if len(enter[c]) > 0 {
+				cost[c] = enter[c][0].cost
+				via[c] = enter[c][0].i
 			}
-			min := distances[0]
-			cent := 0
-			for i, dist := range distances {
-				if dist < min {
-					min = dist
-					cent = i
+			for c2 := range move[c] {
+				for len(move[c][c2]) > 0 && flow[move[c][c2][0].i][c] <= eps {
+					heap.Pop(&move[c][c2])
 				}
 			}
-			clusters[cent] = append(clusters[cent], point)
 		}
 
-		newcentroids := calcCentroids(clusters)
-		looping = compareCentroids(centroids, newcentroids)
-		centroids = newcentroids
-		finalclusters = clusters
-	}
+		for round := 0; round < k; round++ {
+			changed := false
+			for c := range cost {
+				if math.IsInf(cost[c], 1) {
+					continue
+				}
+				for c2 := range cost {
+					if c2 == c || len(move[c][c2]) == 0 {
+						continue
+					}
+					top := move[c][c2][0]
+					if nd := cost[c] + top.cost; nd < cost[c2]-1e-9*math.Max(1, math.Abs(cost[c2])) {
+						cost[c2] = nd
+						from[c2] = c
+						via[c2] = top.i
+						changed = true
+					}
+				}
+			}
+			if !changed {
+				break
+			}
+		}
 
-	for i, centroid := range centroids {
-		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+		// Cheapest cluster to end at, filling lower bounds first.
+		end := -1
+		best := math.Inf(1)
+		room := 0.0
+		for c := range cost {
+			var endCost, capacity float64
+			switch {
+			case load[c] < lo-eps:
+				endCost, capacity = -big, lo-load[c]
+			case load[c] < hi-eps:
+				endCost, capacity = 0, hi-load[c]
+			default:
+				continue
+			}
+			if cost[c]+endCost < best {
+				best = cost[c] + endCost
+				end = c
+				room = capacity
+			}
+		}
+		if end < 0 {
+			log.Fatalf("no room left in any cluster with %f still to assign\n", left)
+		}
+
+		// Walk the path back, to find how much it can carry. A point
+		// could show up on the path more than once.
+		type hop struct{ i, c int }
+		uses := make(map[hop]float64)
+		amount := room
+		c := end
+		for steps := 0; from[c] >= 0; steps++ {
+			if steps > k {
+				log.Fatal("cycle in shortest path tree")
+			}
+			uses[hop{via[c], from[c]}]++
+			c = from[c]
+		}
+		amount = math.Min(amount, remaining[via[c]])
+		for h, count := range uses {
+			amount = math.Min(amount, flow[h.i][h.c]/count)
+		}
+
+		c = end
+		load[end] += amount
+		for from[c] >= 0 {
+			i := via[c]
+			arrive(i, c)
+			flow[i][c] += amount
+			flow[i][from[c]] -= amount
+			c = from[c]
+		}
+		arrive(via[c], c)
+		flow[via[c]][c] += amount
+		remaining[via[c]] -= amount
+		left -= amount
 	}
 
-	for i, cluster := range finalclusters {
-		for _, point := range cluster {
-			fmt.Printf("%f %f %d\n", point.x, point.y, i)
+	for i := range flow {
+		for c := range flow[i] {
+			if flow[i][c] < eps {
+				flow[i][c] = 0
+			}
 		}
 	}
+
+	return flow
+}
+
+// candidate point i for a shortest path step costing cost
+type candidate struct {
+	i    int
+	cost float64
+}
+
+type candidateHeap []candidate
+
+func (h candidateHeap) Len() int            { return len(h) }
+func (h candidateHeap) Less(i, j int) bool  { return h[i].cost < h[j].cost }
+func (h candidateHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *candidateHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *candidateHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
 }
 
 func compareCentroids(centroids []Point, newcentroids []Point) bool {
@@ -160,21 +513,35 @@ func (ps PointSlice) Less(i, j int) bool {
 }
 func (ps PointSlice) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
 
-func calcCentroids(clusters [][]Point) []Point {
-	centroids := make([]Point, len(clusters))
-
-	for cent, cluster := range clusters {
-		var sumx, sumy float64
-		for _, point := range cluster {
-			sumx += point.x
-			sumy += point.y
+// Weighted means of what flowed to each cluster. A cluster
+// nothing flowed to keeps its old centroid.
+func calcCentroids(points []Point, flow [][]float64, old []Point) []Point {
+	centroids := make([]Point, len(old))
+	sums := make([]float64, len(old))
+
+	for i, point := range points {
+		for c, f := range flow[i] {
+			centroids[c].x += f * point.x
+			centroids[c].y += f * point.y
+			sums[c] += f
+		}
+	}
+	for c := range centroids {
+		if sums[c] == 0 {
+			centroids[c] = old[c]
+			continue
 		}
-		centroids[cent] = Point{x: sumx / float64(len(cluster)), y: sumy / float64(len(cluster))}
+		centroids[c].x /= sums[c]
+		centroids[c].y /= sums[c]
 	}
 
 	return centroids
 }
 
+/*
+Read "pop x y" lines, or "x y" lines with population 1,
+skipping blank lines and '#' comments.
+*/
 func readPoints(filename string) []Point {
 	fin, err := os.Open(filename)
 	if err != nil {
@@ -184,37 +551,52 @@ func readPoints(filename string) []Point {
 
 	var points []Point
 
-	for {
-		var p Point
-		n, err := fmt.Fscanf(fin, "%f %f %f\n", &p.pop, &p.x, &p.y)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			log.Print(err)
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
 			continue
 		}
-		if n != 3 {
-			log.Printf("Parsed %d items, wanted 3\n", n)
+		if len(fields) == 2 {
+			fields = append([]string{"1"}, fields...)
+		}
+		if len(fields) != 3 {
+			log.Printf("%s line %d: %d fields, wanted 3\n", filename, lineNo, len(fields))
+			continue
+		}
+
+		var p Point
+		var errs [3]error
+		p.pop, errs[0] = strconv.ParseFloat(fields[0], 64)
+		p.x, errs[1] = strconv.ParseFloat(fields[1], 64)
+		p.y, errs[2] = strconv.ParseFloat(fields[2], 64)
+		if errs[0] != nil || errs[1] != nil || errs[2] != nil || p.pop < 0 {
+			log.Printf("%s line %d: can't parse %q\n", filename, lineNo, scanner.Text())
 			continue
 		}
 
 		points = append(points, p)
 	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
 
 	return points
 }
 
-/* k-means++ method of finding initial guesses at centroids.
-
-1. Choose one center uniformly at random among the data points.
-2. For each data point x, compute D(x), the distance between x and the nearest
-   center that has already been chosen.
-3. Choose one new data point at random as a new center, using a weighted
-   probability distribution where a point x is chosen with probability
-   proportional to D(x)^2.
-4. Repeat Steps 2 and 3 until k centers have been chosen.
-5. Now that the initial centers have been chosen, proceed using standard k-means clustering.
+/*
+		k-means++ method of finding initial guesses at centroids.
+
+	 1. Choose one center uniformly at random among the data points.
+	 2. For each data point x, compute D(x), the distance between x and the nearest
+	    center that has already been chosen.
+	 3. Choose one new data point at random as a new center, using a weighted
+	    probability distribution where a point x is chosen with probability
+	    proportional to D(x)^2.
+	 4. Repeat Steps 2 and 3 until k centers have been chosen.
+	 5. Now that the initial centers have been chosen, proceed using standard k-means clustering.
 */
 func kMeansPPCentroids(k int, points []Point) (centroids []Point) {
 
@@ -237,15 +619,15 @@ type interval struct {
 }
 
 /*
-   Choose one new data point at random as a new center, using a weighted
-   probability distribution where a point x is chosen with probability
-   proportional to D(x)^2.
+Choose one new data point at random as a new center, using a weighted
+probability distribution where a point x is chosen with probability
+proportional to D(x)^2.
 
-   I'm not pretending this is optimal - and it may not even be correct,
-   but it's the only way I could think of to get a probability proptional
-   to D^2
+I'm not pretending this is optimal - and it may not even be correct,
+but it's the only way I could think of to get a probability proptional
+to D^2
 
-   Parameter D already has dx^2+dy^2 as the D2 element value.
+Parameter D already has dx^2+dy^2 as the D2 element value.
 */
 func weightedChoice(D []dist) int {
 	sumValues := 0.0
@@ -257,17 +639,10 @@ func weightedChoice(D []dist) int {
 		intervals = append(intervals, interval{maxval: sumValues, index: D[i].pointIndex})
 	}
 
-	fmt.Printf("Intervals %d:\n", len(intervals))
-	for i := range intervals {
-		fmt.Printf("%3d   %2d   %f\n", i, intervals[i].index, intervals[i].maxval)
-	}
-
 	inInterval := rand.Float64() * sumValues
 
 	for i := range intervals {
 		if inInterval < intervals[i].maxval {
-			fmt.Printf("\nSum of D2 %f, random value %f, picking interval %d/%f\n\n",
-				sumValues, inInterval, i, intervals[i].maxval)
 			return intervals[i].index
 		}
 	}
@@ -276,24 +651,19 @@ func weightedChoice(D []dist) int {
 }
 
 /*
-    For each data point x, compute D(x), the distance between x and the nearest
-    center that has already been chosen.
+	    For each data point x, compute D(x), the distance between x and the nearest
+	    center that has already been chosen.
 
-	Actually going to calculate D(x)^2, because that's what's used later in the
-	algorithm.
+		Actually going to calculate D(x)^2, because that's what's used later in the
+		algorithm.
 */
 func fillDistances(D []dist, points []Point, centroids []Point) {
 
 	for idx, point := range points {
-		dx := point.x - centroids[0].x
-		dy := point.y - centroids[0].y
-		minD := dx*dx + dy*dy
+		minD := sqDist(point, centroids[0])
 
 		for _, center := range centroids {
-			dx := point.x - center.x
-			dy := point.y - center.y
-			d := dx*dx + dy*dy
-			if d < minD {
+			if d := sqDist(point, center); d < minD {
 				minD = d
 			}
 		}
@@ -301,109 +671,4 @@ func fillDistances(D []dist, points []Point, centroids []Point) {
 		D[idx].D2 = minD
 		D[idx].pointIndex = idx
 	}
-
-	fmt.Printf("fill distances, %d Points, %d dist, %d centroids\n", len(points), len(D), len(centroids))
-	fmt.Printf("Centroids:\n")
-	for i := range centroids {
-		fmt.Printf("Centroid %d (%f, %f)\n", i, centroids[i].x, centroids[i].y)
-	}
-	for i := range D {
-		fmt.Printf("dist %d: Point %d (%f, %f) dist %f\n", i, D[i].pointIndex,
-			points[D[i].pointIndex].x, points[D[i].pointIndex].y, D[i].D2)
-	}
-}
-
-type pointDist struct {
-	distDiff   float64
-	distances  []dist // distance to centroids, centroid array-order
-	pointIndex int    // here, the pointIndex is that of a centroid
-}
-
-/*
-	Assign points to their preferred cluster until this cluster is full, then
-	resort remaining objects, without taking the full cluster into account
-	anymore
-
-	This sounds like: Once a cluster is full, re-calculate the distance difference
-	based on remaining (non-full clusters), and assign points to their preferred
-	cluster until another cluster fills up.
-
-	Formal parameter orderedPoints should be in descending order by distDiff
-*/
-func assignToClusters(orderedPoints []pointDist, points []Point, k int) (clusters [][]Point) {
-	sumPop := 0.0
-	for i := range points {
-		sumPop += points[i].pop
-	}
-
-	desiredClusterPopulation := sumPop / float64(k)
-	// each element of clusterPopulation is the sum of points' population in that cluster
-	clusterPopulation := make([]float64, k)
-
-	/*
-		type pointDist struct {
-		distDiff   float64
-		distances  []dist // distance to centroids, centroid array-order
-		pointIndex int
-	*/
-
-	for i := range orderedPoints {
-		var centroidIdx int
-		minDist := math.MaxFloat32
-		for j := range orderedPoints[i].dist {
-			if orderedPoints[i].dist[j] < minDist {
-				minDist = orderedPoints[i].dist[j]
-				centroidIdx = j
-			}
-		}
-		relevantPt := points[orderedPoints[i].pointIndex]
-		cluster[centroidIdx] = append(cluster[centroidIdx], relevantPt)
-		clusterPopulation[centroidIdx] += relevantPt.pop
-
-		clusterPopulation[centroidIdx] >= desiredClusterPopulation{
-			// Rework orderdPoints without centroid at centroidIdx
-		}
-	}
 }
-
-/*
-	Order points by the distance to their nearest cluster minus distance to the
-	farthest cluster (= biggest benefit of best over worst assignment)
-
-	Note that this seems to mean sort in descending order by difference distance
-*/
-func orderPointsByDistance(points []Point, centroids []Point) []pointDist {
-	var orderedPoints []pointDist
-	for i := range points {
-		oPoint := pointDist{pointIndex: i}
-		for j := range centroids {
-			dx := points[i].x - centroids[j].x
-			dy := points[i].y - centroids[j].y
-			distToCentroid := math.Sqrt(dx*dx + dy*dy)
-			oPoint.distances = append(oPoint.distances, dist{D2: distToCentroid, pointIndex: j})
-		}
-		ds := distSlice(oPoint.distances)
-		sort.Sort(ds)
-		m := len(centroids)
-		// Remember oPoint.distances is sorted.
-		oPoint.distDiff = oPoint.distances[m-1].D2 - oPoint.distances[0].D2
-
-		orderedPoints = append(orderedPoints, oPoint)
-	}
-
-	sort.Sort(pointDistSlice(orderedPoints))
-
-	return orderedPoints
-}
-
-type distSlice []dist
-
-func (ps distSlice) Len() int           { return len(ps) }
-func (ps distSlice) Less(i, j int) bool { return ps[i].D2 < ps[j].D2 }
-func (ps distSlice) Swap(i, j int)      { ps[i], ps[j] = ps[j], ps[i] }
-
-type pointDistSlice []pointDist
-
-func (pds pointDistSlice) Len() int           { return len(pds) }
-func (pds pointDistSlice) Less(i, j int) bool { return pds[i].distDiff > pds[j].distDiff }
-func (pds pointDistSlice) Swap(i, j int)      { pds[i], pds[j] = pds[j], pds[i] }
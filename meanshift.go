@@ -0,0 +1,367 @@
+package main
+
+/*
+   Mean-shift clustering, which needs neither k nor DBSCAN's eps.
+
+   Usage: meanshift [-kernel flat|gaussian] [-bandwidth h] [-quantile q] [-minbin N] filename
+
+   Every seed climbs uphill in point density: it moves to the mean of the
+   points around it, weighted by the kernel, until it stops moving. Seeds
+   that end up within a bandwidth of each other merge, and every point
+   joins the cluster of the nearest surviving mode.
+
+   The flat kernel weights every point within the bandwidth equally.
+   The Gaussian kernel weights points by exp(-d^2/(2h^2)), out to 3h.
+
+   Without -bandwidth, the bandwidth is the mean distance from a sample
+   of points to their nearest -quantile fraction of the data.
+
+   Rather than start a seed at every point, points get binned on a grid of
+   bandwidth-sized cells, and a seed starts at every cell holding at least
+   -minbin points.
+
+   The modes print as "x y cN" lines, same as km1's centroids, followed
+   by the points in km1's "x y label" format.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+type mode struct {
+	center Point
+	count  int // points within a bandwidth of center
+}
+
+func main() {
+	kernel := flag.String("kernel", "flat", "kernel, flat or gaussian")
+	bandwidth := flag.Float64("bandwidth", 0, "kernel bandwidth, 0 to estimate from the data")
+	quantile := flag.Float64("quantile", 0.3, "fraction of points to use in bandwidth estimate")
+	minBin := flag.Int("minbin", 1, "points a grid cell needs to get a seed")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: meanshift [-kernel flat|gaussian] [-bandwidth h] [-quantile q] [-minbin N] filename")
+	}
+	if *kernel != "flat" && *kernel != "gaussian" {
+		log.Fatalf("unknown kernel %q, want flat or gaussian\n", *kernel)
+	}
+
+	points := readPoints(flag.Arg(0))
+	if len(points) == 0 {
+		log.Fatalf("no points in %s\n", flag.Arg(0))
+	}
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	h := *bandwidth
+	if h <= 0 {
+		h = estimateBandwidth(points, *quantile)
+	}
+	fmt.Fprintf(os.Stderr, "# %s kernel, bandwidth %f\n", *kernel, h)
+
+	tree := newKDTree(points)
+	seeds := binSeeds(points, h, *minBin)
+
+	var modes []mode
+	for _, seed := range seeds {
+		center := shift(seed, points, tree, h, *kernel == "gaussian")
+		count := len(tree.within(center, h))
+		if count > 0 {
+			modes = append(modes, mode{center: center, count: count})
+		}
+	}
+
+	centroids := mergeModes(modes, h)
+	fmt.Fprintf(os.Stderr, "# %d seeds converged to %d modes\n", len(seeds), len(centroids))
+	if len(centroids) == 0 {
+		log.Fatalf("no seed converged to a mode with points within bandwidth %f, try a bigger -bandwidth or smaller -minbin\n", h)
+	}
+
+	clusters := make([][]Point, len(centroids))
+	for _, point := range points {
+		cent := nearestCentroid(point, centroids)
+		clusters[cent] = append(clusters[cent], point)
+	}
+
+	for i, centroid := range centroids {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+
+	for i, cluster := range clusters {
+		for _, point := range cluster {
+			fmt.Printf("%f %f %d\n", point.x, point.y, i)
+		}
+	}
+}
+
+/*
+Mean distance from up to 500 randomly chosen points to their
+quantile*n-th nearest neighbor.
+*/
+func estimateBandwidth(points []Point, quantile float64) float64 {
+	n := len(points)
+	neighbors := int(float64(n) * quantile)
+	if neighbors < 1 {
+		neighbors = 1
+	}
+	if neighbors > n-1 {
+		neighbors = n - 1
+	}
+	if neighbors < 1 {
+		return 1
+	}
+
+	sample := rand.Perm(n)
+	if len(sample) > 500 {
+		sample = sample[:500]
+	}
+
+	sum := 0.0
+	d := make([]float64, n)
+	for _, i := range sample {
+		for j := range points {
+			d[j] = distance(points[i], points[j])
+		}
+		sort.Float64s(d)
+		sum += d[neighbors] // d[0] is the point itself
+	}
+
+	h := sum / float64(len(sample))
+	if h == 0 {
+		h = 1
+	}
+	return h
+}
+
+// Centers of bandwidth-sized grid cells holding at least minBin points.
+func binSeeds(points []Point, h float64, minBin int) []Point {
+	type cell struct{ i, j int64 }
+	counts := make(map[cell]int)
+	for _, p := range points {
+		counts[cell{int64(math.Round(p.x / h)), int64(math.Round(p.y / h))}]++
+	}
+
+	var seeds []Point
+	for c, count := range counts {
+		if count >= minBin {
+			seeds = append(seeds, Point{x: float64(c.i) * h, y: float64(c.j) * h})
+		}
+	}
+	if len(seeds) == 0 {
+		// minBin too big for any cell, fall back to a seed at every point
+		seeds = append(seeds, points...)
+	}
+
+	sort.Sort(PointSlice(seeds))
+	return seeds
+}
+
+/*
+Move center to the kernel-weighted mean of the points around it,
+until it moves less than a thousandth of the bandwidth.
+*/
+func shift(center Point, points []Point, tree *kdTree, h float64, gaussian bool) Point {
+	radius := h
+	if gaussian {
+		radius = 3 * h
+	}
+
+	for iter := 0; iter < 300; iter++ {
+		var sumx, sumy, sumw float64
+		for _, i := range tree.within(center, radius) {
+			w := 1.0
+			if gaussian {
+				d := distance(center, points[i])
+				w = math.Exp(-d * d / (2 * h * h))
+			}
+			sumx += w * points[i].x
+			sumy += w * points[i].y
+			sumw += w
+		}
+		if sumw == 0 {
+			break
+		}
+
+		next := Point{x: sumx / sumw, y: sumy / sumw}
+		moved := distance(center, next)
+		center = next
+		if moved < 1e-3*h {
+			break
+		}
+	}
+
+	return center
+}
+
+/*
+Keep the modes with the most points near them, dropping any
+mode within a bandwidth of one already kept.
+*/
+func mergeModes(modes []mode, h float64) []Point {
+	sort.SliceStable(modes, func(i, j int) bool { return modes[i].count > modes[j].count })
+
+	var kept []Point
+	for _, m := range modes {
+		duplicate := false
+		for _, k := range kept {
+			if distance(m.center, k) < h {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			kept = append(kept, m.center)
+		}
+	}
+
+	return kept
+}
+
+func nearestCentroid(point Point, centroids []Point) int {
+	min := math.Inf(1)
+	cent := 0
+	for i, centroid := range centroids {
+		if d := distance(point, centroid); d < min {
+			min = d
+			cent = i
+		}
+	}
+	return cent
+}
+
+func distance(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+/*
+2-d tree of point indexes. Each node splits on x or y, alternating
+with depth, at the median of the points below it.
+*/
+type kdTree struct {
+	points []Point
+	root   *kdNode
+}
+
+type kdNode struct {
+	index       int
+	axis        int
+	left, right *kdNode
+}
+
+func newKDTree(points []Point) *kdTree {
+	indexes := make([]int, len(points))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	t := &kdTree{points: points}
+	t.root = t.build(indexes, 0)
+	return t
+}
+
+func (t *kdTree) build(indexes []int, depth int) *kdNode {
+	if len(indexes) == 0 {
+		return nil
+	}
+	axis := depth % 2
+	sort.Slice(indexes, func(i, j int) bool {
+		return coord(t.points[indexes[i]], axis) < coord(t.points[indexes[j]], axis)
+	})
+	mid := len(indexes) / 2
+	return &kdNode{
+		index: indexes[mid],
+		axis:  axis,
+		left:  t.build(indexes[:mid], depth+1),
+		right: t.build(indexes[mid+1:], depth+1),
+	}
+}
+
+func coord(p Point, axis int) float64 {
+	if axis == 0 {
+		return p.x
+	}
+	return p.y
+}
+
+// Indexes of all points within distance r of p.
+func (t *kdTree) within(p Point, r float64) []int {
+	var found []int
+	var search func(nd *kdNode)
+	search = func(nd *kdNode) {
+		if nd == nil {
+			return
+		}
+		if distance(p, t.points[nd.index]) <= r {
+			found = append(found, nd.index)
+		}
+		diff := coord(p, nd.axis) - coord(t.points[nd.index], nd.axis)
+		if diff <= r {
+			search(nd.left)
+		}
+		if diff >= -r {
+			search(nd.right)
+		}
+	}
+	search(t.root)
+	return found
+}
+
+type PointSlice []Point
+
+func (ps PointSlice) Len() int { return len(ps) }
+func (ps PointSlice) Less(i, j int) bool {
+	if ps[i].x < ps[j].x {
+		return true
+	}
+	if ps[i].x == ps[j].x {
+		return ps[i].y < ps[j].y
+	}
+	return false
+}
+func (ps PointSlice) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
@@ -0,0 +1,579 @@
+package main
+
+/*
+   Principal component analysis, before clustering, after it, or both.
+
+   Usage: pca [-standardize] [-reduce d] [-k k] [-labeled] filename
+
+   Points can have any number of columns, as long as every line has the
+   same number. km1 and friends only handle x y, and gnuplot only plots
+   two columns, so pca does the N-dimensional part.
+
+   The principal components are the eigenvectors of the covariance
+   matrix, by Jacobi rotations, largest eigenvalue first. With
+   -standardize, columns get divided by their standard deviation first,
+   so it's the correlation matrix instead, which matters when columns
+   come in different units. Each component's share of the total variance
+   goes to stderr, along with its loadings.
+
+   Before: -k k clusters with k-means++ Lloyd's, in the space of the top
+   -reduce components if -reduce is given, which drops the low variance
+   directions that are mostly noise.
+
+   After: points and centroids get projected onto the top two components,
+   and printed in km1's format, "x y cN" centroid lines, then "x y label",
+   so do7's greps and seven.load plot them like any other km1 output.
+   Centroids are the means of their clusters in the original space, then
+   projected.
+
+   With -labeled instead of -k, the last column of every line is a label,
+   and lines labeled cN are centroids, which is km1's output format with
+   more columns. That only does the "after" part, projecting an existing
+   clustering. Without either, every point gets label 0.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Vector a point with any number of coordinates
+type Vector []float64
+
+type dist struct {
+	D2         float64
+	pointIndex int
+}
+
+// PCA centers and scales vectors, then projects them onto components.
+type PCA struct {
+	mean       Vector
+	scale      Vector
+	values     []float64
+	components []Vector // unit eigenvectors, largest eigenvalue first
+}
+
+func main() {
+	standardize := flag.Bool("standardize", false, "divide columns by their standard deviation, PCA on correlations")
+	reduce := flag.Int("reduce", 0, "cluster in the space of this many top components, 0 for all columns")
+	k := flag.Int("k", 0, "cluster into k clusters before projecting")
+	labeled := flag.Bool("labeled", false, "last column is a label, cN for centroids, as in km1 output")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: pca [-standardize] [-reduce d] [-k k] [-labeled] filename")
+	}
+	if *labeled && *k > 0 {
+		log.Fatal("-labeled projects an existing clustering, -k makes a new one, pick one")
+	}
+	if *reduce > 0 && *k == 0 {
+		log.Fatal("-reduce picks the space -k clusters in, it does nothing without -k")
+	}
+
+	vectors, labels, centroids := readVectors(flag.Arg(0), *labeled)
+	if len(vectors) < 2 {
+		log.Fatalf("%d points, need at least 2\n", len(vectors))
+	}
+	dim := len(vectors[0])
+	if *reduce < 0 || *reduce > dim {
+		log.Fatalf("-reduce %d out of range for %d columns\n", *reduce, dim)
+	}
+
+	pca := fitPCA(vectors, *standardize)
+	pca.report(os.Stderr)
+
+	if *k > 0 {
+		if *k > len(vectors) {
+			log.Fatalf("k %d more than %d points\n", *k, len(vectors))
+		}
+		rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+		d := *reduce
+		if d == 0 {
+			d = dim
+		}
+		reduced := make([]Vector, len(vectors))
+		for i, v := range vectors {
+			reduced[i] = pca.project(v, d)
+		}
+		labels = kmeanscluster(*k, reduced)
+		centroids = make(map[int]Vector)
+	}
+
+	// Clusters without a centroid get the mean of their points.
+	means := calcVectorCentroids(vectors, labels)
+	for l, mean := range means {
+		if _, ok := centroids[l]; !ok && l >= 0 {
+			centroids[l] = mean
+		}
+	}
+
+	clusters := make([]int, 0, len(means))
+	for l := range means {
+		clusters = append(clusters, l)
+	}
+	sort.Ints(clusters)
+
+	if *labeled || *k > 0 {
+		for _, l := range clusters {
+			if c, ok := centroids[l]; ok {
+				p := pca.project(c, 2)
+				fmt.Printf("%f %f c%d\n", p[0], p[1], l)
+			}
+		}
+	}
+	for _, l := range clusters {
+		for i, v := range vectors {
+			if labels[i] == l {
+				p := pca.project(v, 2)
+				fmt.Printf("%f %f %d\n", p[0], p[1], l)
+			}
+		}
+	}
+}
+
+/*
+Fit principal components to vectors: column means, optionally
+standard deviations, and the eigenvectors of the covariance matrix
+of the centered, scaled columns.
+*/
+func fitPCA(vectors []Vector, standardize bool) *PCA {
+	dim := len(vectors[0])
+	n := float64(len(vectors))
+
+	pca := &PCA{mean: make(Vector, dim), scale: make(Vector, dim)}
+	for _, v := range vectors {
+		for j := range v {
+			pca.mean[j] += v[j]
+		}
+	}
+	for j := range pca.mean {
+		pca.mean[j] /= n
+		pca.scale[j] = 1
+	}
+
+	if standardize {
+		for j := range pca.scale {
+			sumsq := 0.0
+			for _, v := range vectors {
+				sumsq += (v[j] - pca.mean[j]) * (v[j] - pca.mean[j])
+			}
+			if sd := math.Sqrt(sumsq / (n - 1)); sd > 0 {
+				pca.scale[j] = sd
+			}
+		}
+	}
+
+	cov := make([][]float64, dim)
+	for a := range cov {
+		cov[a] = make([]float64, dim)
+	}
+	for _, v := range vectors {
+		z := pca.center(v)
+		for a := range z {
+			for b := a; b < dim; b++ {
+				cov[a][b] += z[a] * z[b]
+			}
+		}
+	}
+	for a := range cov {
+		for b := a; b < dim; b++ {
+			cov[a][b] /= n - 1
+			cov[b][a] = cov[a][b]
+		}
+	}
+
+	values, vectorsByColumn := jacobiEigen(cov)
+
+	order := make([]int, dim)
+	for e := range order {
+		order[e] = e
+	}
+	sort.Slice(order, func(i, j int) bool { return values[order[i]] > values[order[j]] })
+
+	for _, e := range order {
+		component := make(Vector, dim)
+		for j := range component {
+			component[j] = vectorsByColumn[j][e]
+		}
+		// Sign is arbitrary, make the largest loading positive so
+		// plots don't flip from run to run.
+		big := 0
+		for j := range component {
+			if math.Abs(component[j]) > math.Abs(component[big]) {
+				big = j
+			}
+		}
+		if component[big] < 0 {
+			for j := range component {
+				component[j] = -component[j]
+			}
+		}
+		pca.values = append(pca.values, math.Max(values[e], 0))
+		pca.components = append(pca.components, component)
+	}
+
+	return pca
+}
+
+func (pca *PCA) center(v Vector) Vector {
+	z := make(Vector, len(v))
+	for j := range v {
+		z[j] = (v[j] - pca.mean[j]) / pca.scale[j]
+	}
+	return z
+}
+
+// Coordinates of v along the top d components. Missing components,
+// when there are fewer than d columns, come out 0.
+func (pca *PCA) project(v Vector, d int) Vector {
+	z := pca.center(v)
+	p := make(Vector, d)
+	for e := 0; e < d && e < len(pca.components); e++ {
+		p[e] = dot(z, pca.components[e])
+	}
+	return p
+}
+
+func (pca *PCA) report(w *os.File) {
+	total := 0.0
+	for _, value := range pca.values {
+		total += value
+	}
+	cumulative := 0.0
+	for e, value := range pca.values {
+		explained := 0.0
+		if total > 0 {
+			explained = value / total
+		}
+		cumulative += explained
+		loadings := make([]string, len(pca.components[e]))
+		for j, l := range pca.components[e] {
+			loadings[j] = strconv.FormatFloat(l, 'f', 4, 64)
+		}
+		fmt.Fprintf(w, "# PC%d variance %f explained %.4f cumulative %.4f loadings %s\n",
+			e+1, value, explained, cumulative, strings.Join(loadings, " "))
+	}
+}
+
+func dot(a, b Vector) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+/*
+Eigenvalues and eigenvectors of a small symmetric matrix by cyclic
+Jacobi rotations. Eigenvector e is column e of the returned matrix.
+*/
+func jacobiEigen(matrix [][]float64) ([]float64, [][]float64) {
+	n := len(matrix)
+	a := make([][]float64, n)
+	v := make([][]float64, n)
+	norm := 0.0
+	for i := range a {
+		a[i] = append([]float64(nil), matrix[i]...)
+		v[i] = make([]float64, n)
+		v[i][i] = 1
+		for j := range a[i] {
+			norm += a[i][j] * a[i][j]
+		}
+	}
+
+	for sweep := 0; sweep < 100; sweep++ {
+		off := 0.0
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				off += a[i][j] * a[i][j]
+			}
+		}
+		if off <= 1e-24*norm {
+			break
+		}
+
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if math.Abs(a[p][q]) < 1e-300 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				for i := 0; i < n; i++ {
+					aip, aiq := a[i][p], a[i][q]
+					a[i][p] = c*aip - s*aiq
+					a[i][q] = s*aip + c*aiq
+				}
+				for i := 0; i < n; i++ {
+					api, aqi := a[p][i], a[q][i]
+					a[p][i] = c*api - s*aqi
+					a[q][i] = s*api + c*aqi
+				}
+				for i := 0; i < n; i++ {
+					vip, viq := v[i][p], v[i][q]
+					v[i][p] = c*vip - s*viq
+					v[i][q] = s*vip + c*viq
+				}
+			}
+		}
+	}
+
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = a[i][i]
+	}
+	return values, v
+}
+
+/*
+Lloyd's algorithm, same as km1's kmeanscluster, but on vectors of
+any dimension, and returning each vector's cluster instead of printing.
+*/
+func kmeanscluster(k int, vectors []Vector) []int {
+
+	centroids := kMeansPPCentroids(k, vectors)
+
+	looping := true
+
+	var labels []int
+
+	for looping {
+		labels = assign(vectors, centroids)
+		newcentroids := make([]Vector, k)
+		means := calcVectorCentroids(vectors, labels)
+		for c := range newcentroids {
+			if mean, ok := means[c]; ok {
+				newcentroids[c] = mean
+			} else {
+				newcentroids[c] = centroids[c] // an empty cluster keeps its old centroid
+			}
+		}
+		looping = compareCentroids(centroids, newcentroids)
+		centroids = newcentroids
+	}
+
+	return labels
+}
+
+func assign(vectors []Vector, centroids []Vector) []int {
+	labels := make([]int, len(vectors))
+	for i, v := range vectors {
+		min := math.Inf(1)
+		for c, centroid := range centroids {
+			if d := sqDistance(v, centroid); d < min {
+				min = d
+				labels[i] = c
+			}
+		}
+	}
+	return labels
+}
+
+// Mean of the vectors with each label.
+func calcVectorCentroids(vectors []Vector, labels []int) map[int]Vector {
+	centroids := make(map[int]Vector)
+	counts := make(map[int]float64)
+	for i, v := range vectors {
+		c, ok := centroids[labels[i]]
+		if !ok {
+			c = make(Vector, len(v))
+			centroids[labels[i]] = c
+		}
+		counts[labels[i]]++
+		for d := range v {
+			c[d] += v[d]
+		}
+	}
+	for l, c := range centroids {
+		for d := range c {
+			c[d] /= counts[l]
+		}
+	}
+	return centroids
+}
+
+func compareCentroids(centroids []Vector, newcentroids []Vector) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		if sqDistance(centroids[i], newcentroids[i]) > 1e-12 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+func sqDistance(a, b Vector) float64 {
+	sum := 0.0
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+/*
+k-means++ method of finding initial guesses at centroids.
+
+ 1. Choose one center uniformly at random among the data points.
+ 2. For each data point x, compute D(x), the distance between x and the nearest
+    center that has already been chosen.
+ 3. Choose one new data point at random as a new center, using a weighted
+    probability distribution where a point x is chosen with probability
+    proportional to D(x)^2.
+ 4. Repeat Steps 2 and 3 until k centers have been chosen.
+*/
+func kMeansPPCentroids(k int, vectors []Vector) (centroids []Vector) {
+
+	centroids = append(centroids, vectors[rand.Intn(len(vectors))])
+
+	D := make([]dist, len(vectors))
+
+	for i := 0; i < k-1; i++ {
+		fillDistances(D, vectors, centroids)
+		newCenterIndex := weightedChoice(D)
+		centroids = append(centroids, vectors[newCenterIndex])
+	}
+
+	return
+}
+
+type interval struct {
+	maxval float64
+	index  int
+}
+
+/*
+Choose one new data point at random as a new center, using a weighted
+probability distribution where a point x is chosen with probability
+proportional to D(x)^2.
+*/
+func weightedChoice(D []dist) int {
+	sumValues := 0.0
+
+	var intervals []interval
+
+	for i := range D {
+		sumValues += D[i].D2
+		intervals = append(intervals, interval{maxval: sumValues, index: D[i].pointIndex})
+	}
+
+	inInterval := rand.Float64() * sumValues
+
+	for i := range intervals {
+		if inInterval < intervals[i].maxval {
+			return intervals[i].index
+		}
+	}
+
+	return 0
+}
+
+/*
+For each data point x, compute D(x)^2, the squared distance between x
+and the nearest center that has already been chosen.
+*/
+func fillDistances(D []dist, vectors []Vector, centroids []Vector) {
+
+	for idx, v := range vectors {
+		minD := sqDistance(v, centroids[0])
+
+		for _, center := range centroids {
+			if d := sqDistance(v, center); d < minD {
+				minD = d
+			}
+		}
+
+		D[idx].D2 = minD
+		D[idx].pointIndex = idx
+	}
+}
+
+/*
+Read whitespace separated columns, one point per line, skipping blank
+lines and '#' comments. With labeled, the last column is a label, and
+lines labeled cN are centroids rather than points.
+*/
+func readVectors(filename string, labeled bool) ([]Vector, []int, map[int]Vector) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var vectors []Vector
+	var labels []int
+	centroids := make(map[int]Vector)
+	dim := -1
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		label, isCentroid := 0, false
+		if labeled {
+			last := fields[len(fields)-1]
+			fields = fields[:len(fields)-1]
+			isCentroid = strings.HasPrefix(last, "c")
+			label, err = strconv.Atoi(strings.TrimPrefix(last, "c"))
+			if err != nil {
+				log.Printf("%s line %d: bad label %q\n", filename, lineNo, last)
+				continue
+			}
+		}
+
+		v := make(Vector, len(fields))
+		bad := false
+		for j, field := range fields {
+			if v[j], err = strconv.ParseFloat(field, 64); err != nil {
+				bad = true
+			}
+		}
+		if bad || len(v) == 0 {
+			log.Printf("%s line %d: can't parse %q\n", filename, lineNo, scanner.Text())
+			continue
+		}
+		if dim < 0 {
+			dim = len(v)
+		}
+		if len(v) != dim {
+			log.Fatalf("%s line %d: %d columns, earlier lines have %d\n", filename, lineNo, len(v), dim)
+		}
+
+		if isCentroid {
+			centroids[label] = v
+			continue
+		}
+		vectors = append(vectors, v)
+		labels = append(labels, label)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return vectors, labels, centroids
+}
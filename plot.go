@@ -0,0 +1,657 @@
+package main
+
+/*
+   Scatter plots of clusters as PNG or SVG files, no gnuplot needed.
+
+   Usage: plot [-png file] [-svg file] [-size N] [-hulls] [-voronoi] [-meta file] filename
+
+   filename is km1 output, or anything else in km1's format. Points get
+   colored by cluster, noise points labeled -1 gray, and centroids, the
+   "x y cN" lines, are angry red dots, like seven.load draws them.
+   Clusters without a centroid line get none.
+
+   -hulls outlines every cluster's convex hull in its color.
+   -voronoi shades the Voronoi cell of every centroid, the part of the
+   plane closer to it than to any other centroid, which is where km1
+   would put a new point.
+   -meta reads the JSON file genblob -m writes, and marks the true blob
+   centers with black crosses.
+
+   Both axes use the same scale, so distances and Voronoi cells look
+   right. Everything gets drawn with the standard library, no display
+   or fonts needed, so it works headless. With neither -png nor -svg,
+   it writes out.png.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+// Blobs is the metadata genblob writes with -m.
+type Blobs struct {
+	Blobs   int      `json:"blobs"`
+	Points  int      `json:"points"`
+	Radius  float64  `json:"radius"`
+	Centers []Center `json:"centers"`
+}
+
+// Center of one blob, and how many points it got.
+type Center struct {
+	Blob   int     `json:"blob"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Points int     `json:"points"`
+}
+
+// Plot holds what gets drawn, and maps data coordinates to pixels.
+type Plot struct {
+	points    []Point
+	labels    []int
+	centroids map[int]Point
+	blobs     []Point
+	hulls     bool
+	voronoi   bool
+
+	size   int
+	margin float64
+	min    Point // data coordinates at the plot area's lower left
+	max    Point
+	scale  float64 // pixels per data unit
+}
+
+const noise = -1
+
+// gnuplot's default colors, except red, which is for centroids, and
+// black, which is for blob centers, then some more.
+var palette = []color.RGBA{
+	{0x94, 0x00, 0xd3, 0xff},
+	{0x00, 0x9e, 0x73, 0xff},
+	{0x56, 0xb4, 0xe9, 0xff},
+	{0xe6, 0x9f, 0x00, 0xff},
+	{0xf0, 0xe4, 0x42, 0xff},
+	{0x00, 0x72, 0xb2, 0xff},
+	{0x9a, 0xcd, 0x32, 0xff},
+	{0xa0, 0x52, 0x2d, 0xff},
+	{0x80, 0x80, 0x00, 0xff},
+	{0xff, 0x69, 0xb4, 0xff},
+	{0x2f, 0x4f, 0x4f, 0xff},
+	{0x00, 0xce, 0xd1, 0xff},
+}
+
+var (
+	red   = color.RGBA{0xff, 0x00, 0x00, 0xff}
+	gray  = color.RGBA{0xa0, 0xa0, 0xa0, 0xff}
+	black = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	white = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+func clusterColor(label int) color.RGBA {
+	if label < 0 {
+		return gray
+	}
+	return palette[label%len(palette)]
+}
+
+// c mixed with white, fraction f of the way.
+func tint(c color.RGBA, f float64) color.RGBA {
+	mix := func(v uint8) uint8 { return uint8(float64(v) + f*(255-float64(v))) }
+	return color.RGBA{mix(c.R), mix(c.G), mix(c.B), 0xff}
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func main() {
+	pngFile := flag.String("png", "", "write a PNG image to this file")
+	svgFile := flag.String("svg", "", "write an SVG image to this file")
+	size := flag.Int("size", 800, "image width and height, in pixels")
+	hulls := flag.Bool("hulls", false, "outline each cluster's convex hull")
+	voronoi := flag.Bool("voronoi", false, "shade each centroid's Voronoi cell")
+	metaFile := flag.String("meta", "", "genblob -m JSON file, to mark true blob centers")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: plot [-png file] [-svg file] [-size N] [-hulls] [-voronoi] [-meta file] filename")
+	}
+	if *size < 100 {
+		log.Fatalf("size %d too small, at least 100\n", *size)
+	}
+	if *pngFile == "" && *svgFile == "" {
+		*pngFile = "out.png"
+	}
+
+	p := readClustering(flag.Arg(0))
+	p.hulls = *hulls
+	p.voronoi = *voronoi
+	if *metaFile != "" {
+		p.blobs = readBlobs(*metaFile)
+	}
+	p.fit(*size)
+
+	if *pngFile != "" {
+		p.writePNG(*pngFile)
+	}
+	if *svgFile != "" {
+		p.writeSVG(*svgFile)
+	}
+}
+
+/*
+Fit everything into a size by size image, with a margin for tick
+labels, padding the data's range by 5% and using the same scale on
+both axes.
+*/
+func (p *Plot) fit(size int) {
+	p.size = size
+	p.margin = 50
+
+	all := append([]Point(nil), p.points...)
+	for _, c := range p.centroids {
+		all = append(all, c)
+	}
+	all = append(all, p.blobs...)
+	if len(all) == 0 {
+		all = []Point{{0, 0}}
+	}
+
+	lo, hi := all[0], all[0]
+	for _, q := range all {
+		lo.x, lo.y = math.Min(lo.x, q.x), math.Min(lo.y, q.y)
+		hi.x, hi.y = math.Max(hi.x, q.x), math.Max(hi.y, q.y)
+	}
+	span := math.Max(hi.x-lo.x, hi.y-lo.y)
+	if span == 0 {
+		span = 1
+	}
+	span *= 1.1
+	center := Point{x: (lo.x + hi.x) / 2, y: (lo.y + hi.y) / 2}
+
+	p.min = Point{x: center.x - span/2, y: center.y - span/2}
+	p.max = Point{x: center.x + span/2, y: center.y + span/2}
+	p.scale = (float64(size) - 2*p.margin) / span
+}
+
+// Pixel coordinates of a data point, y growing downward.
+func (p *Plot) pixel(q Point) (float64, float64) {
+	return p.margin + (q.x-p.min.x)*p.scale, float64(p.size) - p.margin - (q.y-p.min.y)*p.scale
+}
+
+// Data coordinates of a pixel.
+func (p *Plot) data(px, py float64) Point {
+	return Point{x: p.min.x + (px-p.margin)/p.scale, y: p.min.y + (float64(p.size)-p.margin-py)/p.scale}
+}
+
+// Centroid labels in order.
+func (p *Plot) centroidLabels() []int {
+	var labels []int
+	for l := range p.centroids {
+		labels = append(labels, l)
+	}
+	sort.Ints(labels)
+	return labels
+}
+
+// Convex hull of every cluster, by label.
+func (p *Plot) clusterHulls() map[int][]Point {
+	members := make(map[int][]Point)
+	for i, q := range p.points {
+		if p.labels[i] != noise {
+			members[p.labels[i]] = append(members[p.labels[i]], q)
+		}
+	}
+	hulls := make(map[int][]Point)
+	for l, m := range members {
+		hulls[l] = convexHull(m)
+	}
+	return hulls
+}
+
+/*
+Ticks at round numbers, 1, 2 or 5 times a power of ten apart, about
+six across the plot. Returns the tick values and the decimals to
+label them with.
+*/
+func (p *Plot) ticks(lo, hi float64) ([]float64, int) {
+	raw := (hi - lo) / 6
+	step := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*step >= raw {
+			step *= m
+			break
+		}
+	}
+	digits := int(math.Max(0, -math.Floor(math.Log10(step))))
+
+	var values []float64
+	for v := math.Ceil(lo/step) * step; v <= hi; v += step {
+		values = append(values, v)
+	}
+	return values, digits
+}
+
+// Tick value v as text, with no "-0" from rounding error.
+func tickLabel(v float64, digits int) string {
+	label := strconv.FormatFloat(v, 'f', digits, 64)
+	if strings.Trim(label, "-0.") == "" {
+		label = strings.TrimPrefix(label, "-")
+	}
+	return label
+}
+
+func (p *Plot) writePNG(filename string) {
+	img := image.NewRGBA(image.Rect(0, 0, p.size, p.size))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	if p.voronoi && len(p.centroids) > 0 {
+		p.drawVoronoi(img)
+	}
+
+	left, bottom := p.margin, float64(p.size)-p.margin
+	right, top := float64(p.size)-p.margin, p.margin
+	for _, edge := range [][4]float64{{left, top, right, top}, {right, top, right, bottom},
+		{right, bottom, left, bottom}, {left, bottom, left, top}} {
+		drawLine(img, edge[0], edge[1], edge[2], edge[3], 1, black)
+	}
+	xs, digits := p.ticks(p.min.x, p.max.x)
+	for _, v := range xs {
+		px, _ := p.pixel(Point{x: v, y: p.min.y})
+		drawLine(img, px, bottom, px, bottom+5, 1, black)
+		label := tickLabel(v, digits)
+		drawText(img, px-float64(textWidth(label))/2, bottom+10, label, black)
+	}
+	ys, digits := p.ticks(p.min.y, p.max.y)
+	for _, v := range ys {
+		_, py := p.pixel(Point{x: p.min.x, y: v})
+		drawLine(img, left-5, py, left, py, 1, black)
+		label := tickLabel(v, digits)
+		drawText(img, left-8-float64(textWidth(label)), py-5, label, black)
+	}
+
+	if p.hulls {
+		for l, hull := range p.clusterHulls() {
+			for i := range hull {
+				x0, y0 := p.pixel(hull[i])
+				x1, y1 := p.pixel(hull[(i+1)%len(hull)])
+				drawLine(img, x0, y0, x1, y1, 2, clusterColor(l))
+			}
+		}
+	}
+
+	for i, q := range p.points {
+		px, py := p.pixel(q)
+		fillCircle(img, px, py, 2.5, clusterColor(p.labels[i]))
+	}
+
+	for _, b := range p.blobs {
+		px, py := p.pixel(b)
+		drawLine(img, px-7, py-7, px+7, py+7, 3, black)
+		drawLine(img, px-7, py+7, px+7, py-7, 3, black)
+	}
+
+	for _, l := range p.centroidLabels() {
+		px, py := p.pixel(p.centroids[l])
+		fillCircle(img, px, py, 7, red)
+	}
+
+	fout, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := png.Encode(fout, img); err != nil {
+		log.Fatal(err)
+	}
+	if err := fout.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Shade every pixel of the plot area by its nearest centroid,
+// darker where the nearest centroid changes.
+func (p *Plot) drawVoronoi(img *image.RGBA) {
+	labels := p.centroidLabels()
+	lo, hi := int(p.margin), p.size-int(p.margin)
+	nearest := make([][]int, hi-lo)
+	for py := lo; py < hi; py++ {
+		nearest[py-lo] = make([]int, hi-lo)
+		for px := lo; px < hi; px++ {
+			q := p.data(float64(px)+0.5, float64(py)+0.5)
+			min := math.Inf(1)
+			for _, l := range labels {
+				if d := sqDist(q, p.centroids[l]); d < min {
+					min = d
+					nearest[py-lo][px-lo] = l
+				}
+			}
+		}
+	}
+	for y := range nearest {
+		for x, l := range nearest[y] {
+			c := tint(clusterColor(l), 0.85)
+			if x+1 < len(nearest[y]) && nearest[y][x+1] != l || y+1 < len(nearest) && nearest[y+1][x] != l {
+				c = gray
+			}
+			img.SetRGBA(x+lo, y+lo, c)
+		}
+	}
+}
+
+func fillCircle(img *image.RGBA, cx, cy, r float64, c color.RGBA) {
+	for y := int(math.Floor(cy - r)); y <= int(math.Ceil(cy+r)); y++ {
+		for x := int(math.Floor(cx - r)); x <= int(math.Ceil(cx+r)); x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dx*dx+dy*dy <= r*r {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// A line width pixels wide, as dots every half pixel along it.
+func drawLine(img *image.RGBA, x0, y0, x1, y1, width float64, c color.RGBA) {
+	steps := int(math.Ceil(2*math.Max(math.Abs(x1-x0), math.Abs(y1-y0)))) + 1
+	for s := 0; s <= steps; s++ {
+		t := float64(s) / float64(steps)
+		x, y := x0+t*(x1-x0), y0+t*(y1-y0)
+		if width <= 1 {
+			img.SetRGBA(int(x), int(y), c)
+		} else {
+			fillCircle(img, x, y, width/2, c)
+		}
+	}
+}
+
+// 3x5 pixel glyphs for tick labels, one string per row.
+var glyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'-': {"...", "...", "###", "...", "..."},
+	'.': {"...", "...", "...", "...", ".#."},
+}
+
+const glyphScale = 2
+
+func textWidth(s string) int {
+	return len(s) * 4 * glyphScale
+}
+
+// Text with its top left corner at x, y.
+func drawText(img *image.RGBA, x, y float64, s string, c color.RGBA) {
+	for i, r := range s {
+		glyph, ok := glyphs[r]
+		if !ok {
+			continue
+		}
+		for row, bits := range glyph {
+			for col, bit := range bits {
+				if bit != '#' {
+					continue
+				}
+				for dy := 0; dy < glyphScale; dy++ {
+					for dx := 0; dx < glyphScale; dx++ {
+						img.SetRGBA(int(x)+(i*4+col)*glyphScale+dx, int(y)+row*glyphScale+dy, c)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (p *Plot) writeSVG(filename string) {
+	fout, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(fout)
+
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		p.size, p.size, p.size, p.size)
+	fmt.Fprintf(w, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+
+	if p.voronoi && len(p.centroids) > 0 {
+		labels := p.centroidLabels()
+		box := []Point{p.min, {x: p.max.x, y: p.min.y}, p.max, {x: p.min.x, y: p.max.y}}
+		for _, l := range labels {
+			cell := box
+			for _, other := range labels {
+				if other != l {
+					cell = clipCloser(cell, p.centroids[l], p.centroids[other])
+				}
+			}
+			if len(cell) < 3 {
+				continue
+			}
+			fmt.Fprintf(w, "<polygon points=\"%s\" fill=\"%s\" stroke=\"%s\" stroke-width=\"1\"/>\n",
+				p.svgPoints(cell), hex(tint(clusterColor(l), 0.85)), hex(gray))
+		}
+	}
+
+	left, bottom := p.margin, float64(p.size)-p.margin
+	width := float64(p.size) - 2*p.margin
+	fmt.Fprintf(w, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" fill=\"none\" stroke=\"black\"/>\n",
+		left, p.margin, width, width)
+	fmt.Fprintf(w, "<g font-family=\"sans-serif\" font-size=\"12\">\n")
+	xs, digits := p.ticks(p.min.x, p.max.x)
+	for _, v := range xs {
+		px, _ := p.pixel(Point{x: v, y: p.min.y})
+		fmt.Fprintf(w, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"black\"/>\n", px, bottom, px, bottom+5)
+		fmt.Fprintf(w, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"middle\">%s</text>\n",
+			px, bottom+20, tickLabel(v, digits))
+	}
+	ys, digits := p.ticks(p.min.y, p.max.y)
+	for _, v := range ys {
+		_, py := p.pixel(Point{x: p.min.x, y: v})
+		fmt.Fprintf(w, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"black\"/>\n", left-5, py, left, py)
+		fmt.Fprintf(w, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"end\">%s</text>\n",
+			left-8, py+4, tickLabel(v, digits))
+	}
+	fmt.Fprintf(w, "</g>\n")
+
+	if p.hulls {
+		hulls := p.clusterHulls()
+		var labels []int
+		for l := range hulls {
+			labels = append(labels, l)
+		}
+		sort.Ints(labels)
+		for _, l := range labels {
+			fmt.Fprintf(w, "<polygon points=\"%s\" fill=\"none\" stroke=\"%s\" stroke-width=\"2\"/>\n",
+				p.svgPoints(hulls[l]), hex(clusterColor(l)))
+		}
+	}
+
+	for i, q := range p.points {
+		px, py := p.pixel(q)
+		fmt.Fprintf(w, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"2.5\" fill=\"%s\"/>\n", px, py, hex(clusterColor(p.labels[i])))
+	}
+
+	for _, b := range p.blobs {
+		px, py := p.pixel(b)
+		fmt.Fprintf(w, "<path d=\"M%.1f %.1f L%.1f %.1f M%.1f %.1f L%.1f %.1f\" stroke=\"black\" stroke-width=\"3\"/>\n",
+			px-7, py-7, px+7, py+7, px-7, py+7, px+7, py-7)
+	}
+
+	for _, l := range p.centroidLabels() {
+		px, py := p.pixel(p.centroids[l])
+		fmt.Fprintf(w, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"7\" fill=\"red\"/>\n", px, py)
+	}
+
+	fmt.Fprintf(w, "</svg>\n")
+
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := fout.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (p *Plot) svgPoints(polygon []Point) string {
+	coords := make([]string, len(polygon))
+	for i, q := range polygon {
+		px, py := p.pixel(q)
+		coords[i] = fmt.Sprintf("%.1f,%.1f", px, py)
+	}
+	return strings.Join(coords, " ")
+}
+
+/*
+Clip a convex polygon to the half plane of points at least as close to
+a as to b, Sutherland-Hodgman style. That half plane is where
+2(b-a).q <= |b|^2 - |a|^2.
+*/
+func clipCloser(polygon []Point, a, b Point) []Point {
+	nx, ny := 2*(b.x-a.x), 2*(b.y-a.y)
+	limit := b.x*b.x + b.y*b.y - a.x*a.x - a.y*a.y
+	side := func(q Point) float64 { return nx*q.x + ny*q.y - limit }
+
+	var clipped []Point
+	for i := range polygon {
+		cur, next := polygon[i], polygon[(i+1)%len(polygon)]
+		sc, sn := side(cur), side(next)
+		if sc <= 0 {
+			clipped = append(clipped, cur)
+		}
+		if (sc < 0) != (sn < 0) && sc != sn {
+			t := sc / (sc - sn)
+			clipped = append(clipped, Point{x: cur.x + t*(next.x-cur.x), y: cur.y + t*(next.y-cur.y)})
+		}
+	}
+	return clipped
+}
+
+// Convex hull by Andrew's monotone chain, counterclockwise.
+func convexHull(points []Point) []Point {
+	ps := append([]Point(nil), points...)
+	sort.Slice(ps, func(a, b int) bool {
+		if ps[a].x != ps[b].x {
+			return ps[a].x < ps[b].x
+		}
+		return ps[a].y < ps[b].y
+	})
+	if len(ps) < 3 {
+		return ps
+	}
+
+	cross := func(o, a, b Point) float64 {
+		return (a.x-o.x)*(b.y-o.y) - (a.y-o.y)*(b.x-o.x)
+	}
+	var hull []Point
+	for _, q := range ps {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], q) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, q)
+	}
+	lower := len(hull) + 1
+	for i := len(ps) - 2; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], ps[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, ps[i])
+	}
+	return hull[:len(hull)-1]
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+func readBlobs(filename string) []Point {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blobs := &Blobs{}
+	if err := json.Unmarshal(buf, blobs); err != nil {
+		log.Fatalf("%s: %v\n", filename, err)
+	}
+	var centers []Point
+	for _, c := range blobs.Centers {
+		centers = append(centers, Point{x: c.X, y: c.Y})
+	}
+	return centers
+}
+
+/*
+Read km1 output. Centroid lines look like "x y cN", point lines like
+"x y N", noise points have label -1. Lines starting with '#' are
+comments.
+*/
+func readClustering(filename string) *Plot {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	p := &Plot{centroids: make(map[int]Point)}
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			log.Printf("%s line %d: %d fields, wanted 3\n", filename, lineNo, len(fields))
+			continue
+		}
+		x, errx := strconv.ParseFloat(fields[0], 64)
+		y, erry := strconv.ParseFloat(fields[1], 64)
+		if errx != nil || erry != nil {
+			log.Printf("%s line %d: bad coordinates\n", filename, lineNo)
+			continue
+		}
+		label := fields[2]
+		isCentroid := strings.HasPrefix(label, "c")
+		l, err := strconv.Atoi(strings.TrimPrefix(label, "c"))
+		if err != nil || l < noise {
+			log.Printf("%s line %d: bad label %q\n", filename, lineNo, label)
+			continue
+		}
+		if isCentroid {
+			p.centroids[l] = Point{x: x, y: y}
+			continue
+		}
+		p.points = append(p.points, Point{x: x, y: y})
+		p.labels = append(p.labels, l)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return p
+}
@@ -0,0 +1,185 @@
+package main
+
+/*
+   Label new points with a model km1 saved with its -model flag,
+   without re-clustering.
+
+   Usage: predict modelfile [filename]
+
+   Reads "x y" points from filename, or stdin if there's no filename,
+   and gives each the label of its nearest centroid in the model.
+   Output is the model's centroids as "x y cN" lines, then the points,
+   in input order, in km1's "x y label" format.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+// Model is what a trained clustering saves, so that predict can
+// label new points without re-clustering.
+type Model struct {
+	Dimension int         `json:"dimension"`
+	Metric    string      `json:"metric"`
+	Scaling   Scaling     `json:"scaling"`
+	Centroids [][]float64 `json:"centroids"`
+	Training  Training    `json:"training"`
+}
+
+// Scaling maps a raw coordinate v to (v - Center)/Scale,
+// per dimension, before measuring distance to centroids.
+type Scaling struct {
+	Method string    `json:"method"`
+	Center []float64 `json:"center"`
+	Scale  []float64 `json:"scale"`
+}
+
+// Training metadata, for people reading the model file.
+type Training struct {
+	File       string    `json:"file"`
+	Points     int       `json:"points"`
+	K          int       `json:"k"`
+	Iterations int       `json:"iterations"`
+	SSE        float64   `json:"sse"`
+	Sizes      []int     `json:"sizes"`
+	Trained    time.Time `json:"trained"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal("usage: predict modelfile [filename]")
+	}
+
+	model := readModel(os.Args[1])
+
+	in := os.Stdin
+	if len(os.Args) > 2 {
+		fin, err := os.Open(os.Args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fin.Close()
+		in = fin
+	}
+	points := readPoints(in)
+
+	for i, centroid := range model.Centroids {
+		raw := model.unscale(centroid)
+		fmt.Printf("%f %f c%d\n", raw[0], raw[1], i)
+	}
+
+	for _, point := range points {
+		fmt.Printf("%f %f %d\n", point.x, point.y, model.predict(point))
+	}
+}
+
+func readModel(filename string) *Model {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	model := &Model{}
+	if err := json.Unmarshal(buf, model); err != nil {
+		log.Fatalf("model %s: %v\n", filename, err)
+	}
+
+	if model.Dimension != 2 {
+		log.Fatalf("model %s: dimension %d, only 2 supported\n", filename, model.Dimension)
+	}
+	if model.Metric != "euclidean" {
+		log.Fatalf("model %s: unknown metric %q\n", filename, model.Metric)
+	}
+	if len(model.Scaling.Center) != model.Dimension || len(model.Scaling.Scale) != model.Dimension {
+		log.Fatalf("model %s: scaling doesn't match dimension %d\n", filename, model.Dimension)
+	}
+	for j, scale := range model.Scaling.Scale {
+		center := model.Scaling.Center[j]
+		if scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) || math.IsNaN(center) || math.IsInf(center, 0) {
+			log.Fatalf("model %s: bad scaling for dimension %d, center %g scale %g\n", filename, j, center, scale)
+		}
+	}
+	if len(model.Centroids) == 0 {
+		log.Fatalf("model %s: no centroids\n", filename)
+	}
+	for i, centroid := range model.Centroids {
+		if len(centroid) != model.Dimension {
+			log.Fatalf("model %s: centroid %d has %d coordinates, want %d\n",
+				filename, i, len(centroid), model.Dimension)
+		}
+	}
+
+	return model
+}
+
+// Index of the centroid nearest point, in the model's scaled space.
+func (m *Model) predict(point Point) int {
+	v := m.scale([]float64{point.x, point.y})
+
+	min := math.Inf(1)
+	cent := 0
+	for i, centroid := range m.Centroids {
+		d := 0.0
+		for j := range v {
+			d += (v[j] - centroid[j]) * (v[j] - centroid[j])
+		}
+		if d < min {
+			min = d
+			cent = i
+		}
+	}
+
+	return cent
+}
+
+func (m *Model) scale(v []float64) []float64 {
+	scaled := make([]float64, len(v))
+	for j := range v {
+		scaled[j] = (v[j] - m.Scaling.Center[j]) / m.Scaling.Scale[j]
+	}
+	return scaled
+}
+
+func (m *Model) unscale(v []float64) []float64 {
+	raw := make([]float64, len(v))
+	for j := range v {
+		raw[j] = v[j]*m.Scaling.Scale[j] + m.Scaling.Center[j]
+	}
+	return raw
+}
+
+func readPoints(fin io.Reader) []Point {
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
@@ -0,0 +1,258 @@
+package main
+
+/*
+   Differentially private k-means, for publishing centroids of
+   locations without giving away any one location.
+
+   Usage: privatekm [-epsilon e] [-iterations N] [-box xmin,ymin,xmax,ymax] [-points] filename k
+
+   DPLloyd (Blum, Dwork, McSherry & Nissim, and Su et al.): Lloyd's
+   algorithm, except calcCentroids sees each cluster's point count and
+   coordinate sums only through Laplace noise.
+
+   Clipping. Noise has to cover the most one point can change a sum,
+   so every point gets clipped to the -box declared on the command line,
+   genrand's 0 to 1500 square by default. The box can't come from the
+   data, that would leak. Coordinates get shifted to the box center
+   before summing, so one point moves a cluster's x and y sums by at
+   most half the box width plus half its height, together.
+
+   Initialization. k-means++ picks actual data points as centroids, which
+   would publish them. Initial centroids here are uniformly random in
+   the box instead, which costs no privacy.
+
+   Budget. Every iteration spends epsilon/iterations, half on counts,
+   half on sums. A point is in exactly one cluster, so the k clusters'
+   noisy counts together cost only that iteration's count share, by
+   parallel composition, and likewise sums. Iterations add up, by
+   sequential composition, to epsilon in all. Stopping early when
+   centroids settle would depend on the data, so it always runs
+   -iterations times.
+
+   A noisy count under 1 leaves that centroid where it was. Noisy
+   centroids get clipped back into the box.
+
+   Output is the centroids as "x y cN" lines, and the privacy accounting,
+   iteration by iteration, on stderr. -points adds every point's cluster
+   in km1's format, which is not private, for the data's owner to look at.
+
+   This uses math/rand and floating point Laplace noise, fine for
+   experimenting, but a real release wants a cryptographic random source
+   and noise that's safe from floating point attacks (Mironov, 2012).
+*/
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+// Box is the bounding box every point gets clipped to.
+type Box struct {
+	min Point
+	max Point
+}
+
+func main() {
+	epsilon := flag.Float64("epsilon", 1.0, "total privacy budget")
+	iterations := flag.Int("iterations", 5, "Lloyd's iterations, each spending epsilon/iterations")
+	boxSpec := flag.String("box", "0,0,1500,1500", "bounding box xmin,ymin,xmax,ymax to clip points to")
+	printPoints := flag.Bool("points", false, "also print every point's cluster, which is NOT private")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: privatekm [-epsilon e] [-iterations N] [-box xmin,ymin,xmax,ymax] [-points] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if k < 1 {
+		log.Fatalf("k %d must be at least 1\n", k)
+	}
+	if *epsilon <= 0 {
+		log.Fatalf("epsilon %f must be positive\n", *epsilon)
+	}
+	if *iterations < 1 {
+		log.Fatalf("iterations %d must be at least 1\n", *iterations)
+	}
+	box := parseBox(*boxSpec)
+
+	points := readPoints(flag.Arg(0))
+	for i := range points {
+		points[i] = box.clip(points[i])
+	}
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	centroids := make([]Point, k)
+	for c := range centroids {
+		centroids[c] = Point{
+			x: box.min.x + rand.Float64()*(box.max.x-box.min.x),
+			y: box.min.y + rand.Float64()*(box.max.y-box.min.y),
+		}
+	}
+
+	perIteration := *epsilon / float64(*iterations)
+	countEpsilon := perIteration / 2
+	sumEpsilon := perIteration / 2
+	sensitivity := (box.max.x-box.min.x)/2 + (box.max.y-box.min.y)/2
+
+	fmt.Fprintf(os.Stderr, "# epsilon %f over %d iterations, %f each: counts %f, sums %f\n",
+		*epsilon, *iterations, perIteration, countEpsilon, sumEpsilon)
+	fmt.Fprintf(os.Stderr, "# count noise Laplace(%f), sum noise Laplace(%f), sum sensitivity %f\n",
+		1/countEpsilon, sensitivity/sumEpsilon, sensitivity)
+
+	var counts []float64
+	spent := 0.0
+	for iteration := 1; iteration <= *iterations; iteration++ {
+		labels := assign(points, centroids)
+		centroids, counts = noisyCentroids(k, points, labels, centroids, box, countEpsilon, sumEpsilon, sensitivity)
+		spent += countEpsilon + sumEpsilon
+		fmt.Fprintf(os.Stderr, "# iteration %d spent %f, total spent %f of %f\n", iteration, perIteration, spent, *epsilon)
+	}
+
+	for c, count := range counts {
+		fmt.Fprintf(os.Stderr, "# cluster %d noisy count %.1f\n", c, count)
+	}
+
+	for i, centroid := range centroids {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+	if *printPoints {
+		labels := assign(points, centroids)
+		for c := range centroids {
+			for i, point := range points {
+				if labels[i] == c {
+					fmt.Printf("%f %f %d\n", point.x, point.y, c)
+				}
+			}
+		}
+	}
+}
+
+/*
+calcCentroids with Laplace noise on every cluster's count, and on its
+x and y sums, taken relative to the box center. Returns the new
+centroids and the noisy counts.
+*/
+func noisyCentroids(k int, points []Point, labels []int, old []Point, box Box, countEpsilon, sumEpsilon, sensitivity float64) ([]Point, []float64) {
+	center := Point{x: (box.min.x + box.max.x) / 2, y: (box.min.y + box.max.y) / 2}
+
+	sums := make([]Point, k)
+	counts := make([]float64, k)
+	for i, point := range points {
+		sums[labels[i]].x += point.x - center.x
+		sums[labels[i]].y += point.y - center.y
+		counts[labels[i]]++
+	}
+
+	centroids := make([]Point, k)
+	for c := range centroids {
+		counts[c] += laplace(1 / countEpsilon)
+		sums[c].x += laplace(sensitivity / sumEpsilon)
+		sums[c].y += laplace(sensitivity / sumEpsilon)
+
+		if counts[c] < 1 {
+			centroids[c] = old[c]
+			continue
+		}
+		centroids[c] = box.clip(Point{
+			x: center.x + sums[c].x/counts[c],
+			y: center.y + sums[c].y/counts[c],
+		})
+	}
+
+	return centroids, counts
+}
+
+// Laplace noise with scale b, the difference of two exponentials.
+func laplace(b float64) float64 {
+	return b * (rand.ExpFloat64() - rand.ExpFloat64())
+}
+
+func assign(points []Point, centroids []Point) []int {
+	labels := make([]int, len(points))
+	for i, point := range points {
+		min := math.Inf(1)
+		for c, centroid := range centroids {
+			dx := centroid.x - point.x
+			dy := centroid.y - point.y
+			if d := dx*dx + dy*dy; d < min {
+				min = d
+				labels[i] = c
+			}
+		}
+	}
+	return labels
+}
+
+func (b Box) clip(p Point) Point {
+	return Point{
+		x: math.Max(b.min.x, math.Min(b.max.x, p.x)),
+		y: math.Max(b.min.y, math.Min(b.max.y, p.y)),
+	}
+}
+
+func parseBox(spec string) Box {
+	fields := strings.Split(spec, ",")
+	if len(fields) != 4 {
+		log.Fatalf("-box %q: want xmin,ymin,xmax,ymax\n", spec)
+	}
+	var v [4]float64
+	for i, field := range fields {
+		var err error
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+			log.Fatalf("-box %q: %v\n", spec, err)
+		}
+	}
+	box := Box{min: Point{x: v[0], y: v[1]}, max: Point{x: v[2], y: v[3]}}
+	if box.min.x >= box.max.x || box.min.y >= box.max.y {
+		log.Fatalf("-box %q: empty box\n", spec)
+	}
+	return box
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
@@ -0,0 +1,318 @@
+package main
+
+/*
+   How well did clustering recover genblob's blobs?
+
+   Usage: recovery metafile filename
+
+   metafile is the JSON genblob -m writes, holding the true blob centers.
+   filename is km1 output, or anything else in km1's format, with
+   centroids as "x y cN" lines. Clusters without a centroid line get
+   the mean of their points.
+
+   True centers and found centroids get paired up one-to-one by the
+   Hungarian algorithm, minimizing total squared distance. For every
+   blob, prints the true center, the matched centroid and the distance
+   between them, then the mean distance.
+
+   A found centroid that's the nearest centroid to more than one blob
+   center means those blobs got merged. A blob center that's the nearest
+   blob center to more than one found centroid means that blob got split.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+// Blobs is the metadata genblob writes with -m.
+type Blobs struct {
+	Blobs   int      `json:"blobs"`
+	Points  int      `json:"points"`
+	Radius  float64  `json:"radius"`
+	Centers []Center `json:"centers"`
+}
+
+// Center of one blob, and how many points it got.
+type Center struct {
+	Blob   int     `json:"blob"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Points int     `json:"points"`
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		log.Fatal("usage: recovery metafile filename")
+	}
+
+	blobs := readBlobs(os.Args[1])
+	centroids, labels := readCentroids(os.Args[2])
+	if len(centroids) == 0 {
+		log.Fatalf("no centroids in %s\n", os.Args[2])
+	}
+
+	truth := make([]Point, len(blobs.Centers))
+	for i, c := range blobs.Centers {
+		truth[i] = Point{x: c.X, y: c.Y}
+	}
+
+	n := len(truth)
+	if len(centroids) > n {
+		n = len(centroids)
+	}
+	cost := make([][]float64, n)
+	for i := range cost {
+		cost[i] = make([]float64, n)
+		for j := range cost[i] {
+			if i < len(truth) && j < len(centroids) {
+				cost[i][j] = sqDist(truth[i], centroids[j])
+			}
+		}
+	}
+	match := hungarian(cost)
+
+	fmt.Printf("%4s %11s %11s %5s %11s %11s %11s\n", "blob", "true x", "true y", "found", "found x", "found y", "error")
+	sumErr := 0.0
+	matched := 0
+	for i, c := range blobs.Centers {
+		j := match[i]
+		if j >= len(centroids) {
+			fmt.Printf("%4d %11f %11f %5s\n", c.Blob, c.X, c.Y, "none")
+			continue
+		}
+		e := math.Sqrt(sqDist(truth[i], centroids[j]))
+		sumErr += e
+		matched++
+		fmt.Printf("%4d %11f %11f %5s %11f %11f %11f\n",
+			c.Blob, c.X, c.Y, fmt.Sprintf("c%d", labels[j]), centroids[j].x, centroids[j].y, e)
+	}
+	if matched > 0 {
+		fmt.Printf("mean error %f over %d blobs\n", sumErr/float64(matched), matched)
+	}
+	for j := range centroids {
+		if i := indexOf(match, j); i < 0 || i >= len(truth) {
+			fmt.Printf("c%d at %f %f matches no blob\n", labels[j], centroids[j].x, centroids[j].y)
+		}
+	}
+
+	// Blobs sharing a nearest centroid got merged.
+	byCentroid := make([][]int, len(centroids))
+	for i := range truth {
+		j := nearest(truth[i], centroids)
+		byCentroid[j] = append(byCentroid[j], blobs.Centers[i].Blob)
+	}
+	for j, bs := range byCentroid {
+		if len(bs) > 1 {
+			fmt.Printf("merged: blobs %s share nearest centroid c%d\n", joinInts(bs), labels[j])
+		}
+	}
+
+	// Centroids sharing a nearest blob center split that blob.
+	byBlob := make([][]int, len(truth))
+	for j := range centroids {
+		i := nearest(centroids[j], truth)
+		byBlob[i] = append(byBlob[i], j)
+	}
+	for i, cs := range byBlob {
+		if len(cs) > 1 {
+			names := make([]string, len(cs))
+			for k, j := range cs {
+				names[k] = fmt.Sprintf("c%d", labels[j])
+			}
+			fmt.Printf("split: blob %d is the nearest blob to %s\n", blobs.Centers[i].Blob, strings.Join(names, ", "))
+		}
+	}
+}
+
+func indexOf(xs []int, x int) int {
+	for i := range xs {
+		if xs[i] == x {
+			return i
+		}
+	}
+	return -1
+}
+
+func joinInts(xs []int) string {
+	s := make([]string, len(xs))
+	for i, x := range xs {
+		s[i] = strconv.Itoa(x)
+	}
+	return strings.Join(s, ", ")
+}
+
+func nearest(p Point, candidates []Point) int {
+	min := math.Inf(1)
+	best := 0
+	for i, c := range candidates {
+		if d := sqDist(p, c); d < min {
+			min = d
+			best = i
+		}
+	}
+	return best
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+/*
+Hungarian algorithm, O(n^3), with row and column potentials.
+Returns the column assigned to each row that minimizes total cost.
+*/
+func hungarian(cost [][]float64) []int {
+	n := len(cost)
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1) // p[j] is the row matched to column j, 1-based
+	way := make([]int, n+1)
+
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0 := p[j0]
+			delta := math.Inf(1)
+			j1 := 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost[i0-1][j-1] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	match := make([]int, n)
+	for j := 1; j <= n; j++ {
+		if p[j] > 0 {
+			match[p[j]-1] = j - 1
+		}
+	}
+	return match
+}
+
+func readBlobs(filename string) *Blobs {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blobs := &Blobs{}
+	if err := json.Unmarshal(buf, blobs); err != nil {
+		log.Fatalf("%s: %v\n", filename, err)
+	}
+	if len(blobs.Centers) == 0 {
+		log.Fatalf("%s: no blob centers\n", filename)
+	}
+	return blobs
+}
+
+/*
+Centroids from km1 output, "x y cN" lines, and their labels N.
+Any cluster without one gets the mean of its "x y N" points.
+A label with neither, a gap in the numbering, gets skipped.
+*/
+func readCentroids(filename string) ([]Point, []int) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	found := make(map[int]Point)
+	sums := make(map[int]Point)
+	counts := make(map[int]float64)
+	k := 0
+
+	scanner := bufio.NewScanner(fin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		x, errx := strconv.ParseFloat(fields[0], 64)
+		y, erry := strconv.ParseFloat(fields[1], 64)
+		if errx != nil || erry != nil {
+			continue
+		}
+		label := fields[2]
+		isCentroid := strings.HasPrefix(label, "c")
+		l, err := strconv.Atoi(strings.TrimPrefix(label, "c"))
+		if err != nil || l < 0 {
+			continue // noise points, labeled -1, belong to no cluster
+		}
+		if l >= k {
+			k = l + 1
+		}
+		if isCentroid {
+			found[l] = Point{x: x, y: y}
+			continue
+		}
+		s := sums[l]
+		sums[l] = Point{x: s.x + x, y: s.y + y}
+		counts[l]++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	var centroids []Point
+	var labels []int
+	for l := 0; l < k; l++ {
+		if c, ok := found[l]; ok {
+			centroids = append(centroids, c)
+			labels = append(labels, l)
+		} else if counts[l] > 0 {
+			centroids = append(centroids, Point{x: sums[l].x / counts[l], y: sums[l].y / counts[l]})
+			labels = append(labels, l)
+		}
+	}
+	return centroids, labels
+}
@@ -0,0 +1,638 @@
+package main
+
+/*
+   Regionalization: cluster units into k regions, every one a connected
+   piece of a neighbor graph, with populations as equal as they'll go.
+
+   Usage: regions [-adjacency file] [-knn N] [-tolerance t] [-passes N] filename k
+
+   Units come from filename as "pop x y" lines, like km3 reads, or "x y"
+   lines with population 1. The adjacency file has one "i j" line per
+   pair of neighboring units, i and j being 0-based line numbers in
+   filename, like counties that share a border. Without -adjacency, every
+   unit neighbors its -knn nearest units.
+
+   km3 can balance populations, but nothing keeps one of its clusters
+   from being two separate pieces. regions starts from a SKATER-style
+   partition (Assuncao, Neves, Camara & Freitas): a minimum spanning tree
+   of the neighbor graph, edges weighted by distance, gets cut into k
+   subtrees, each cut picked to split its tree's population as close to
+   a whole number of equal shares as possible. Subtrees of a spanning
+   tree are connected, so every region starts out connected.
+
+   Then a local search, in the style of AZP and max-p, moves units on a
+   region's border to a neighboring region, as long as the region it
+   leaves stays connected. First it takes any move that lowers the sum of
+   squared population deviations. Once every region is within -tolerance
+   of an equal share, it switches to moves that keep them there and make
+   regions more compact, lowering the population weighted sum of squared
+   distances from units to their region's center.
+
+   Output is km1's format, region centers as "x y cN" lines. For every
+   region, stderr gets its unit count, population, deviation from an
+   equal share, and the Polsby-Popper compactness 4*pi*area/perimeter^2
+   of the convex hull of its units: 1 for a circle, smaller for
+   stretched out regions, 0 for fewer than three units.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point an x,y cartesian point, with a population
+type Point struct {
+	pop float64
+	x   float64
+	y   float64
+}
+
+type edge struct {
+	i, j int
+	d    float64
+}
+
+// Region totals, enough to get population and a
+// population weighted sum of squared distances.
+type Region struct {
+	units int
+	pop   float64
+	sx    float64
+	sy    float64
+	sxx   float64
+}
+
+func (r Region) add(p Point, sign float64) Region {
+	w := p.pop
+	r.units += int(sign)
+	r.pop += sign * w
+	r.sx += sign * w * p.x
+	r.sy += sign * w * p.y
+	r.sxx += sign * w * (p.x*p.x + p.y*p.y)
+	return r
+}
+
+func (r Region) inertia() float64 {
+	if r.pop <= 0 {
+		return 0
+	}
+	return r.sxx - (r.sx*r.sx+r.sy*r.sy)/r.pop
+}
+
+func main() {
+	adjacencyFile := flag.String("adjacency", "", "file of \"i j\" neighboring unit pairs")
+	knn := flag.Int("knn", 6, "without -adjacency, neighbors are this many nearest units")
+	tolerance := flag.Float64("tolerance", 0.05, "fraction off an equal share that counts as balanced")
+	passes := flag.Int("passes", 100, "most local search passes over all units")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: regions [-adjacency file] [-knn N] [-tolerance t] [-passes N] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	units := readPoints(flag.Arg(0))
+	n := len(units)
+	if k < 1 || k > n {
+		log.Fatalf("k %d out of range for %d units\n", k, n)
+	}
+
+	var neighbors [][]int
+	if *adjacencyFile != "" {
+		neighbors = readAdjacency(*adjacencyFile, n)
+	} else {
+		neighbors = nearestNeighbors(units, *knn)
+	}
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	total := 0.0
+	for _, u := range units {
+		total += u.pop
+	}
+	target := total / float64(k)
+
+	labels := skater(units, neighbors, k)
+	moves := localSearch(units, neighbors, labels, k, target, *tolerance, *passes)
+
+	regions := make([]Region, k)
+	for i, u := range units {
+		regions[labels[i]] = regions[labels[i]].add(u, 1)
+	}
+
+	fmt.Fprintf(os.Stderr, "# %d units, %d regions, equal share %f, %d local search moves\n", n, k, target, moves)
+	worst := 0.0
+	for r, region := range regions {
+		var hull []Point
+		for i, u := range units {
+			if labels[i] == r {
+				hull = append(hull, u)
+			}
+		}
+		deviation := (region.pop - target) / target
+		worst = math.Max(worst, math.Abs(deviation))
+		fmt.Fprintf(os.Stderr, "# region %d, %d units, population %.0f, deviation %+.2f%%, compactness %.3f\n",
+			r, region.units, region.pop, 100*deviation, polsbyPopper(convexHull(hull)))
+	}
+	fmt.Fprintf(os.Stderr, "# largest deviation %.2f%%\n", 100*worst)
+
+	for r, region := range regions {
+		x, y := region.sx/region.pop, region.sy/region.pop
+		if region.pop <= 0 {
+			x, y = 0, 0
+			for i, u := range units {
+				if labels[i] == r {
+					x += u.x / float64(region.units)
+					y += u.y / float64(region.units)
+				}
+			}
+		}
+		fmt.Printf("%f %f c%d\n", x, y, r)
+	}
+	for r := range regions {
+		for i, u := range units {
+			if labels[i] == r {
+				fmt.Printf("%f %f %d\n", u.x, u.y, r)
+			}
+		}
+	}
+}
+
+/*
+SKATER-style initial regions: cut the minimum spanning forest of the
+neighbor graph into k trees. Each piece of the graph gets regions in
+proportion to its population, at least one, and each tree with r > 1
+regions to make gets cut at the edge that splits off closest to j
+equal shares of its population, for some j < r, then each side gets
+split again.
+*/
+func skater(units []Point, neighbors [][]int, k int) []int {
+	n := len(units)
+
+	var edges []edge
+	for i := range neighbors {
+		for _, j := range neighbors[i] {
+			if i < j {
+				edges = append(edges, edge{i: i, j: j, d: math.Sqrt(sqDist(units[i], units[j]))})
+			}
+		}
+	}
+	sort.Slice(edges, func(a, b int) bool { return edges[a].d < edges[b].d })
+
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	tree := make([][]int, n)
+	for _, e := range edges {
+		ri, rj := find(parent, e.i), find(parent, e.j)
+		if ri != rj {
+			parent[ri] = rj
+			tree[e.i] = append(tree[e.i], e.j)
+			tree[e.j] = append(tree[e.j], e.i)
+		}
+	}
+
+	// Pieces of the neighbor graph, and their populations.
+	var pieces [][]int
+	seen := make([]bool, n)
+	for i := range units {
+		if !seen[i] {
+			pieces = append(pieces, component(tree, i, seen))
+		}
+	}
+	if len(pieces) > k {
+		log.Fatalf("neighbor graph is in %d separate pieces, more than k = %d regions\n", len(pieces), k)
+	}
+	if len(pieces) > 1 {
+		fmt.Fprintf(os.Stderr, "# neighbor graph is in %d separate pieces\n", len(pieces))
+	}
+
+	pops := make([]float64, len(pieces))
+	counts := make([]int, len(pieces))
+	for p, piece := range pieces {
+		for _, i := range piece {
+			pops[p] += units[i].pop
+		}
+		counts[p] = 1
+	}
+	// Hand out the rest of the regions one at a time, D'Hondt style.
+	for given := len(pieces); given < k; given++ {
+		best := -1
+		for p := range pieces {
+			if counts[p] >= len(pieces[p]) {
+				continue
+			}
+			if best < 0 || pops[p]/float64(counts[p]+1) > pops[best]/float64(counts[best]+1) {
+				best = p
+			}
+		}
+		counts[best]++
+	}
+
+	labels := make([]int, n)
+	next := 0
+	for p, piece := range pieces {
+		splitTree(units, tree, piece, counts[p], labels, &next)
+	}
+	return labels
+}
+
+// Units reachable from start in graph, marking them seen.
+func component(graph [][]int, start int, seen []bool) []int {
+	seen[start] = true
+	members := []int{start}
+	for q := 0; q < len(members); q++ {
+		for _, j := range graph[members[q]] {
+			if !seen[j] {
+				seen[j] = true
+				members = append(members, j)
+			}
+		}
+	}
+	return members
+}
+
+/*
+Split the tree spanning members into r regions, labeled from *next on.
+Cuts edges out of tree as it goes.
+*/
+func splitTree(units []Point, tree [][]int, members []int, r int, labels []int, next *int) {
+	if r == 1 {
+		for _, i := range members {
+			labels[i] = *next
+		}
+		*next++
+		return
+	}
+
+	// Root the tree at members[0]. Subtree populations and sizes,
+	// adding up children in reverse breadth first order.
+	root := members[0]
+	up := make(map[int]int)
+	up[root] = -1
+	order := []int{root}
+	for q := 0; q < len(order); q++ {
+		for _, j := range tree[order[q]] {
+			if _, ok := up[j]; !ok {
+				up[j] = order[q]
+				order = append(order, j)
+			}
+		}
+	}
+	subPop := make(map[int]float64)
+	subSize := make(map[int]int)
+	for q := len(order) - 1; q >= 0; q-- {
+		i := order[q]
+		subPop[i] += units[i].pop
+		subSize[i]++
+		if up[i] >= 0 {
+			subPop[up[i]] += subPop[i]
+			subSize[up[i]] += subSize[i]
+		}
+	}
+	total := subPop[root]
+	share := total / float64(r)
+
+	cut, shares := -1, 0
+	best := math.Inf(1)
+	for _, i := range order[1:] {
+		for j := 1; j < r; j++ {
+			if subSize[i] < j || len(order)-subSize[i] < r-j {
+				continue
+			}
+			if miss := math.Abs(subPop[i] - float64(j)*share); miss < best {
+				best = miss
+				cut, shares = i, j
+			}
+		}
+	}
+
+	tree[cut] = remove(tree[cut], up[cut])
+	tree[up[cut]] = remove(tree[up[cut]], cut)
+
+	seen := make([]bool, len(units))
+	below := component(tree, cut, seen)
+	above := component(tree, root, seen)
+	splitTree(units, tree, below, shares, labels, next)
+	splitTree(units, tree, above, r-shares, labels, next)
+}
+
+func remove(xs []int, x int) []int {
+	for i := range xs {
+		if xs[i] == x {
+			return append(xs[:i], xs[i+1:]...)
+		}
+	}
+	return xs
+}
+
+/*
+Move border units between neighboring regions, keeping every region
+connected. Until all regions are within tolerance, moves that lower
+the sum of squared population deviations go. After that, moves that
+keep them within it and lower total inertia go. Returns the number
+of moves made.
+*/
+func localSearch(units []Point, neighbors [][]int, labels []int, k int, target, tolerance float64, passes int) int {
+	regions := make([]Region, k)
+	for i, u := range units {
+		regions[labels[i]] = regions[labels[i]].add(u, 1)
+	}
+
+	deviation := func(r Region) float64 { return (r.pop - target) / target }
+	balanced := func(r Region) bool { return math.Abs(deviation(r)) <= tolerance }
+	allBalanced := func() bool {
+		for _, r := range regions {
+			if !balanced(r) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Once balanced, only moves that keep every region balanced
+	// happen, so the search can't cycle between the two goals.
+	balancedYet := allBalanced()
+	moves := 0
+	for pass := 0; pass < passes; pass++ {
+		moved := false
+		for _, i := range rand.Perm(len(units)) {
+			a := labels[i]
+			if regions[a].units == 1 {
+				continue
+			}
+			tried := make(map[int]bool)
+			for _, j := range neighbors[i] {
+				b := labels[j]
+				if b == a || tried[b] {
+					continue
+				}
+				tried[b] = true
+
+				oldA, oldB := regions[a], regions[b]
+				newA, newB := oldA.add(units[i], -1), oldB.add(units[i], 1)
+
+				var better bool
+				if balancedYet {
+					better = balanced(newA) && balanced(newB) &&
+						newA.inertia()+newB.inertia() < oldA.inertia()+oldB.inertia()-1e-9
+				} else {
+					dA, dB := deviation(oldA), deviation(oldB)
+					nA, nB := deviation(newA), deviation(newB)
+					better = nA*nA+nB*nB < dA*dA+dB*dB-1e-12
+				}
+				if !better || !connectedWithout(neighbors, labels, i) {
+					continue
+				}
+
+				labels[i] = b
+				regions[a], regions[b] = newA, newB
+				moves++
+				moved = true
+				balancedYet = balancedYet || allBalanced()
+				break
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+
+	return moves
+}
+
+// Whether unit i's region stays connected with i taken out.
+func connectedWithout(neighbors [][]int, labels []int, i int) bool {
+	region := labels[i]
+	start := -1
+	size := 0
+	for j, l := range labels {
+		if l == region && j != i {
+			size++
+			if start < 0 {
+				start = j
+			}
+		}
+	}
+	if start < 0 {
+		return false
+	}
+
+	seen := map[int]bool{start: true, i: true}
+	queue := []int{start}
+	for q := 0; q < len(queue); q++ {
+		for _, j := range neighbors[queue[q]] {
+			if labels[j] == region && !seen[j] {
+				seen[j] = true
+				queue = append(queue, j)
+			}
+		}
+	}
+	return len(queue) == size
+}
+
+// Convex hull by Andrew's monotone chain, counterclockwise.
+func convexHull(points []Point) []Point {
+	ps := append([]Point(nil), points...)
+	sort.Slice(ps, func(a, b int) bool {
+		if ps[a].x != ps[b].x {
+			return ps[a].x < ps[b].x
+		}
+		return ps[a].y < ps[b].y
+	})
+	if len(ps) < 3 {
+		return ps
+	}
+
+	cross := func(o, a, b Point) float64 {
+		return (a.x-o.x)*(b.y-o.y) - (a.y-o.y)*(b.x-o.x)
+	}
+	var hull []Point
+	for _, p := range ps {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(ps) - 2; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], ps[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, ps[i])
+	}
+	return hull[:len(hull)-1]
+}
+
+// 4*pi*area/perimeter^2 of a polygon, 0 if it has no area.
+func polsbyPopper(polygon []Point) float64 {
+	if len(polygon) < 3 {
+		return 0
+	}
+	area, perimeter := 0.0, 0.0
+	for i := range polygon {
+		a, b := polygon[i], polygon[(i+1)%len(polygon)]
+		area += a.x*b.y - b.x*a.y
+		perimeter += math.Sqrt(sqDist(a, b))
+	}
+	area = math.Abs(area) / 2
+	if perimeter == 0 {
+		return 0
+	}
+	return 4 * math.Pi * area / (perimeter * perimeter)
+}
+
+// Every unit's knn nearest units, made symmetric.
+func nearestNeighbors(units []Point, knn int) [][]int {
+	n := len(units)
+	if knn > n-1 {
+		knn = n - 1
+	}
+	linked := make([]map[int]bool, n)
+	for i := range linked {
+		linked[i] = make(map[int]bool)
+	}
+	others := make([]int, 0, n)
+	for i := range units {
+		others = others[:0]
+		for j := range units {
+			if j != i {
+				others = append(others, j)
+			}
+		}
+		sort.Slice(others, func(a, b int) bool {
+			return sqDist(units[i], units[others[a]]) < sqDist(units[i], units[others[b]])
+		})
+		for _, j := range others[:knn] {
+			linked[i][j] = true
+			linked[j][i] = true
+		}
+	}
+
+	neighbors := make([][]int, n)
+	for i := range linked {
+		for j := range linked[i] {
+			neighbors[i] = append(neighbors[i], j)
+		}
+		sort.Ints(neighbors[i])
+	}
+	return neighbors
+}
+
+func find(parent []int, i int) int {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+/*
+Read "i j" neighbor pairs, 0-based unit IDs, skipping blank lines
+and '#' comments. Neighbors go both ways.
+*/
+func readAdjacency(filename string, n int) [][]int {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	neighbors := make([][]int, n)
+	linked := make(map[[2]int]bool)
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			log.Fatalf("%s line %d: want \"i j\", got %q\n", filename, lineNo, scanner.Text())
+		}
+		i, erri := strconv.Atoi(fields[0])
+		j, errj := strconv.Atoi(fields[1])
+		if erri != nil || errj != nil || i < 0 || i >= n || j < 0 || j >= n {
+			log.Fatalf("%s line %d: bad unit ID in %q, %d units\n", filename, lineNo, scanner.Text(), n)
+		}
+		if i == j || linked[[2]int{i, j}] {
+			continue
+		}
+		linked[[2]int{i, j}] = true
+		linked[[2]int{j, i}] = true
+		neighbors[i] = append(neighbors[i], j)
+		neighbors[j] = append(neighbors[j], i)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return neighbors
+}
+
+/*
+Read "pop x y" lines, or "x y" lines with population 1,
+skipping blank lines and '#' comments.
+*/
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) == 2 {
+			fields = append([]string{"1"}, fields...)
+		}
+		if len(fields) != 3 {
+			log.Fatalf("%s line %d: %d fields, wanted 3\n", filename, lineNo, len(fields))
+		}
+
+		var p Point
+		var errs [3]error
+		p.pop, errs[0] = strconv.ParseFloat(fields[0], 64)
+		p.x, errs[1] = strconv.ParseFloat(fields[1], 64)
+		p.y, errs[2] = strconv.ParseFloat(fields[2], 64)
+		if errs[0] != nil || errs[1] != nil || errs[2] != nil || p.pop < 0 {
+			log.Fatalf("%s line %d: can't parse %q\n", filename, lineNo, scanner.Text())
+		}
+
+		points = append(points, p)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return points
+}
@@ -0,0 +1,418 @@
+package main
+
+/*
+   Give clusters stable labels from run to run.
+
+   Usage: relabel [-ref file] [-match centroids|labels] [-order size|xy] filename
+
+   km1 picks its initial centroids at random, so the same data can come
+   out with the same clusters under different labels on every run, and
+   do7's colors flicker. relabel rewrites km1-format output (filename)
+   with new labels.
+
+   With -ref, another km1-format file, the labels best matching the
+   reference win, by the Hungarian algorithm. -match centroids pairs up
+   clusters to minimize total squared distance between centroids.
+   -match labels pairs up clusters to maximize the number of points,
+   matched by coordinates, that keep the reference's label.
+   Clusters left over when filename has more clusters than the reference
+   get the labels after the reference's. With fewer clusters than the
+   reference, every cluster keeps the reference label it matched, so
+   labels can skip the numbers of reference clusters that went missing.
+
+   Without -ref, -order size labels clusters biggest first, -order xy
+   labels them by centroid x, then centroid y.
+
+   Noise points, labeled -1 as by dbscan or trimkm, keep that label and
+   play no part in matching or ordering.
+
+   Output is km1's format, centroids as "x y cN" lines, then "x y label",
+   noise last.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+const noise = -1
+
+// Clustering is km1's output: centroids, and points labeled
+// with the index of their centroid.
+type Clustering struct {
+	centroids []Point
+	points    []Point
+	labels    []int
+	unused    []bool // labels no cluster has, after relabeling
+}
+
+func main() {
+	refFile := flag.String("ref", "", "reference clustering, in km1's output format")
+	match := flag.String("match", "centroids", "match reference by centroids or labels")
+	order := flag.String("order", "size", "without -ref, order labels by size or xy")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: relabel [-ref file] [-match centroids|labels] [-order size|xy] filename")
+	}
+
+	c := readClustering(flag.Arg(0))
+
+	var mapping []int
+	if *refFile != "" {
+		ref := readClustering(*refFile)
+		switch *match {
+		case "centroids":
+			mapping = matchCentroids(c, ref)
+		case "labels":
+			mapping = matchLabels(c, ref)
+		default:
+			log.Fatalf("unknown -match %q, want centroids or labels\n", *match)
+		}
+	} else {
+		switch *order {
+		case "size", "xy":
+			mapping = canonicalOrder(c, *order)
+		default:
+			log.Fatalf("unknown -order %q, want size or xy\n", *order)
+		}
+	}
+
+	c.relabel(mapping)
+	c.print()
+}
+
+/*
+Read km1 output. Centroid lines look like "x y cN", point lines like
+"x y N". Lines starting with '#' are comments.
+*/
+func readClustering(filename string) *Clustering {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	c := &Clustering{}
+	centroids := make(map[int]Point)
+	k := 0
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			log.Printf("%s line %d: %d fields, wanted 3\n", filename, lineNo, len(fields))
+			continue
+		}
+		x, errx := strconv.ParseFloat(fields[0], 64)
+		y, erry := strconv.ParseFloat(fields[1], 64)
+		if errx != nil || erry != nil {
+			log.Printf("%s line %d: bad coordinates\n", filename, lineNo)
+			continue
+		}
+
+		label := fields[2]
+		isCentroid := strings.HasPrefix(label, "c")
+		if isCentroid {
+			label = label[1:]
+		}
+		l, err := strconv.Atoi(label)
+		if err != nil || l < noise || isCentroid && l == noise {
+			log.Printf("%s line %d: bad label %q\n", filename, lineNo, fields[2])
+			continue
+		}
+		if l >= k {
+			k = l + 1
+		}
+
+		if isCentroid {
+			centroids[l] = Point{x: x, y: y}
+			continue
+		}
+		c.points = append(c.points, Point{x: x, y: y})
+		c.labels = append(c.labels, l)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Clusters without a centroid line get the mean of their points.
+	c.centroids = make([]Point, k)
+	means := calcCentroids(k, c.points, c.labels)
+	for l := 0; l < k; l++ {
+		if centroid, ok := centroids[l]; ok {
+			c.centroids[l] = centroid
+		} else {
+			c.centroids[l] = means[l]
+		}
+	}
+
+	return c
+}
+
+func calcCentroids(k int, points []Point, labels []int) []Point {
+	centroids := make([]Point, k)
+	counts := make([]float64, k)
+	for i, p := range points {
+		if labels[i] == noise {
+			continue
+		}
+		centroids[labels[i]].x += p.x
+		centroids[labels[i]].y += p.y
+		counts[labels[i]]++
+	}
+	for l := range centroids {
+		if counts[l] > 0 {
+			centroids[l].x /= counts[l]
+			centroids[l].y /= counts[l]
+		}
+	}
+	return centroids
+}
+
+// relabel gives cluster l the label mapping[l].
+func (c *Clustering) relabel(mapping []int) {
+	k := 0
+	for _, l := range mapping {
+		if l >= k {
+			k = l + 1
+		}
+	}
+	centroids := make([]Point, k)
+	c.unused = make([]bool, k)
+	for l := range c.unused {
+		c.unused[l] = true
+	}
+	for l, centroid := range c.centroids {
+		centroids[mapping[l]] = centroid
+		c.unused[mapping[l]] = false
+	}
+	c.centroids = centroids
+	for i := range c.labels {
+		if c.labels[i] != noise {
+			c.labels[i] = mapping[c.labels[i]]
+		}
+	}
+}
+
+func (c *Clustering) print() {
+	for i, centroid := range c.centroids {
+		if !c.unused[i] {
+			fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+		}
+	}
+
+	for l := range c.centroids {
+		for i, point := range c.points {
+			if c.labels[i] == l {
+				fmt.Printf("%f %f %d\n", point.x, point.y, l)
+			}
+		}
+	}
+	for i, point := range c.points {
+		if c.labels[i] == noise {
+			fmt.Printf("%f %f %d\n", point.x, point.y, noise)
+		}
+	}
+}
+
+// Cost of giving cluster i of c the label j of ref is the
+// squared distance between their centroids.
+func matchCentroids(c, ref *Clustering) []int {
+	cost := make([][]float64, len(c.centroids))
+	for i, a := range c.centroids {
+		cost[i] = make([]float64, len(ref.centroids))
+		for j, b := range ref.centroids {
+			dx := a.x - b.x
+			dy := a.y - b.y
+			cost[i][j] = dx*dx + dy*dy
+		}
+	}
+	return assignLabels(cost, len(ref.centroids))
+}
+
+// Cost of giving cluster i of c the label j of ref is minus the
+// number of points labeled i in c and j in ref.
+func matchLabels(c, ref *Clustering) []int {
+	refLabels := make(map[Point][]int)
+	for i, p := range ref.points {
+		if ref.labels[i] != noise {
+			refLabels[p] = append(refLabels[p], ref.labels[i])
+		}
+	}
+
+	cost := make([][]float64, len(c.centroids))
+	for i := range cost {
+		cost[i] = make([]float64, len(ref.centroids))
+	}
+	for i, p := range c.points {
+		if c.labels[i] == noise {
+			continue
+		}
+		if ls := refLabels[p]; len(ls) > 0 {
+			cost[c.labels[i]][ls[0]]--
+			refLabels[p] = ls[1:]
+		}
+	}
+	return assignLabels(cost, len(ref.centroids))
+}
+
+/*
+Solve the assignment problem on a rows x cols cost matrix, padded to
+square with zero cost. Rows matched to padding columns get the labels
+after the reference's, in their original order. The others keep
+the reference label they matched, even if that skips numbers.
+*/
+func assignLabels(cost [][]float64, cols int) []int {
+	rows := len(cost)
+	n := rows
+	if cols > n {
+		n = cols
+	}
+
+	square := make([][]float64, n)
+	for i := range square {
+		square[i] = make([]float64, n)
+		if i < rows {
+			copy(square[i], cost[i])
+		}
+	}
+
+	match := hungarian(square)
+
+	mapping := make([]int, rows)
+	next := cols
+	for i := 0; i < rows; i++ {
+		if match[i] < cols {
+			mapping[i] = match[i]
+		} else {
+			mapping[i] = next
+			next++
+		}
+	}
+
+	return mapping
+}
+
+/*
+Hungarian algorithm, O(n^3), with row and column potentials.
+Returns the column assigned to each row that minimizes total cost.
+*/
+func hungarian(cost [][]float64) []int {
+	n := len(cost)
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1) // p[j] is the row matched to column j, 1-based
+	way := make([]int, n+1)
+
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0 := p[j0]
+			delta := math.Inf(1)
+			j1 := 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost[i0-1][j-1] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	match := make([]int, n)
+	for j := 1; j <= n; j++ {
+		if p[j] > 0 {
+			match[p[j]-1] = j - 1
+		}
+	}
+	return match
+}
+
+/*
+Labels in canonical order: by size, biggest first, or by centroid
+x then y. Ties in size fall back to x then y.
+*/
+func canonicalOrder(c *Clustering, order string) []int {
+	sizes := make([]int, len(c.centroids))
+	for _, l := range c.labels {
+		if l != noise {
+			sizes[l]++
+		}
+	}
+
+	byXY := func(a, b int) bool {
+		if c.centroids[a].x != c.centroids[b].x {
+			return c.centroids[a].x < c.centroids[b].x
+		}
+		return c.centroids[a].y < c.centroids[b].y
+	}
+
+	clusters := make([]int, len(c.centroids))
+	for i := range clusters {
+		clusters[i] = i
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		a, b := clusters[i], clusters[j]
+		if order == "size" && sizes[a] != sizes[b] {
+			return sizes[a] > sizes[b]
+		}
+		return byXY(a, b)
+	})
+
+	mapping := make([]int, len(clusters))
+	for newLabel, old := range clusters {
+		mapping[old] = newLabel
+	}
+	return mapping
+}
@@ -0,0 +1,350 @@
+package main
+
+/*
+   Semi-supervised k-means, for when some points' clusters are known.
+
+   Usage: seededkm [-mode seeded|constrained] filename k
+
+   Reads "x y" lines for unlabeled points and "x y label" lines for points
+   whose cluster is known, label being 0 to k-1. A label of -1 counts as
+   unlabeled, so dbscan-style output works too.
+
+   Seeded k-means (Basu, Banerjee & Mooney) starts cluster l's centroid
+   at the mean of the points labeled l, instead of km1's randomCentroids
+   or km1a's kMeansPPCentroids. Labels that never appear get centroids
+   by k-means++, picking points far from the seeded centroids. After
+   that it's plain Lloyd's algorithm, and labeled points can end up
+   somewhere else if the data says so.
+
+   Constrained-seeded k-means (-mode constrained) starts the same way,
+   but labeled points stay in their labeled cluster through every
+   iteration, and only unlabeled points get assigned to their nearest
+   centroid.
+
+   Output is km1's format, with clusters numbered like the labels.
+   How many labeled points the seeded mode moved away from their label
+   goes to stderr.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+type dist struct {
+	D2         float64
+	pointIndex int
+}
+
+const unlabeled = -1
+
+func main() {
+	mode := flag.String("mode", "seeded", "seeded, or constrained to keep labeled points in their clusters")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: seededkm [-mode seeded|constrained] filename k")
+	}
+	if *mode != "seeded" && *mode != "constrained" {
+		log.Fatalf("unknown -mode %q, want seeded or constrained\n", *mode)
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	points, given := readPoints(flag.Arg(0))
+	if k < 1 || k > len(points) {
+		log.Fatalf("k %d out of range for %d points\n", k, len(points))
+	}
+	labeled := 0
+	for i, l := range given {
+		if l >= k {
+			log.Fatalf("point %d (%f, %f) labeled %d, but k is %d\n", i, points[i].x, points[i].y, l, k)
+		}
+		if l != unlabeled {
+			labeled++
+		}
+	}
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	centroids, seeded := seedCentroids(k, points, given)
+	fmt.Fprintf(os.Stderr, "# %d labeled points seeded %d of %d centroids, k-means++ picked the rest\n",
+		labeled, seeded, k)
+
+	labels, iterations := kmeanscluster(centroids, points, given, *mode == "constrained")
+
+	moved := 0
+	for i, l := range given {
+		if l != unlabeled && labels[i] != l {
+			moved++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "# %d iterations, %d of %d labeled points moved away from their label\n",
+		iterations, moved, labeled)
+
+	for i, centroid := range centroids {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+	for c := range centroids {
+		for i, point := range points {
+			if labels[i] == c {
+				fmt.Printf("%f %f %d\n", point.x, point.y, c)
+			}
+		}
+	}
+}
+
+/*
+Centroid l is the mean of the points labeled l. Labels nobody has get
+k-means++ choices, weighted by squared distance to the centroids so far.
+Returns the centroids and how many came from labels.
+*/
+func seedCentroids(k int, points []Point, given []int) ([]Point, int) {
+	sums := make([]Point, k)
+	counts := make([]float64, k)
+	for i, l := range given {
+		if l == unlabeled {
+			continue
+		}
+		sums[l].x += points[i].x
+		sums[l].y += points[i].y
+		counts[l]++
+	}
+
+	// Centroids chosen so far, for k-means++ to keep away from.
+	var chosen []Point
+	seeded := 0
+	centroids := make([]Point, k)
+	for l := range centroids {
+		if counts[l] > 0 {
+			centroids[l] = Point{x: sums[l].x / counts[l], y: sums[l].y / counts[l]}
+			chosen = append(chosen, centroids[l])
+			seeded++
+		}
+	}
+
+	D := make([]dist, len(points))
+	for l := range centroids {
+		if counts[l] > 0 {
+			continue
+		}
+		if len(chosen) == 0 {
+			// No labels at all, plain k-means++.
+			centroids[l] = points[rand.Intn(len(points))]
+		} else {
+			fillDistances(D, points, chosen)
+			centroids[l] = points[weightedChoice(D)]
+		}
+		chosen = append(chosen, centroids[l])
+	}
+
+	return centroids, seeded
+}
+
+/*
+Lloyd's algorithm from the given centroids. With constrained, labeled
+points keep their labels, and only unlabeled ones get reassigned.
+Returns every point's cluster and the number of iterations.
+*/
+func kmeanscluster(centroids []Point, points []Point, given []int, constrained bool) ([]int, int) {
+
+	k := len(centroids)
+	labels := make([]int, len(points))
+
+	looping := true
+	iterations := 0
+
+	for looping {
+		iterations++
+
+		for i, point := range points {
+			if constrained && given[i] != unlabeled {
+				labels[i] = given[i]
+				continue
+			}
+			labels[i] = nearest(point, centroids)
+		}
+
+		newcentroids := calcCentroids(k, points, labels, centroids)
+		looping = compareCentroids(centroids, newcentroids)
+		copy(centroids, newcentroids)
+	}
+
+	return labels, iterations
+}
+
+func nearest(point Point, centroids []Point) int {
+	min := math.Inf(1)
+	cent := 0
+	for c, centroid := range centroids {
+		if d := sqDist(point, centroid); d < min {
+			min = d
+			cent = c
+		}
+	}
+	return cent
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+func compareCentroids(centroids []Point, newcentroids []Point) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		dx := centroids[i].x - newcentroids[i].x
+		dy := centroids[i].y - newcentroids[i].y
+		dist := dx*dx + dy*dy
+		if dist > 0.01 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+// An empty cluster keeps its old centroid.
+func calcCentroids(k int, points []Point, labels []int, old []Point) []Point {
+	centroids := make([]Point, k)
+	counts := make([]float64, k)
+
+	for i, point := range points {
+		centroids[labels[i]].x += point.x
+		centroids[labels[i]].y += point.y
+		counts[labels[i]]++
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			centroids[c] = old[c]
+			continue
+		}
+		centroids[c].x /= counts[c]
+		centroids[c].y /= counts[c]
+	}
+
+	return centroids
+}
+
+/*
+Read "x y" and "x y label" lines, skipping blank lines and '#'
+comments. Points without a label, or labeled -1, come back unlabeled.
+*/
+func readPoints(filename string) ([]Point, []int) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+	var labels []int
+
+	scanner := bufio.NewScanner(fin)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 && len(fields) != 3 {
+			log.Printf("%s line %d: %d fields, wanted \"x y\" or \"x y label\"\n", filename, lineNo, len(fields))
+			continue
+		}
+		x, errx := strconv.ParseFloat(fields[0], 64)
+		y, erry := strconv.ParseFloat(fields[1], 64)
+		if errx != nil || erry != nil {
+			log.Printf("%s line %d: bad coordinates\n", filename, lineNo)
+			continue
+		}
+		label := unlabeled
+		if len(fields) == 3 {
+			l, err := strconv.Atoi(fields[2])
+			if err != nil || l < unlabeled {
+				log.Printf("%s line %d: bad label %q\n", filename, lineNo, fields[2])
+				continue
+			}
+			label = l
+		}
+
+		points = append(points, Point{x: x, y: y})
+		labels = append(labels, label)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return points, labels
+}
+
+type interval struct {
+	maxval float64
+	index  int
+}
+
+/*
+Choose one new data point at random as a new center, using a weighted
+probability distribution where a point x is chosen with probability
+proportional to D(x)^2.
+*/
+func weightedChoice(D []dist) int {
+	sumValues := 0.0
+
+	var intervals []interval
+
+	for i := range D {
+		sumValues += D[i].D2
+		intervals = append(intervals, interval{maxval: sumValues, index: D[i].pointIndex})
+	}
+
+	inInterval := rand.Float64() * sumValues
+
+	for i := range intervals {
+		if inInterval < intervals[i].maxval {
+			return intervals[i].index
+		}
+	}
+
+	return 0
+}
+
+/*
+For each data point x, compute D(x)^2, the squared distance between x
+and the nearest center that has already been chosen.
+*/
+func fillDistances(D []dist, points []Point, centroids []Point) {
+
+	for idx, point := range points {
+		minD := sqDist(point, centroids[0])
+
+		for _, center := range centroids {
+			if d := sqDist(point, center); d < minD {
+				minD = d
+			}
+		}
+
+		D[idx].D2 = minD
+		D[idx].pointIndex = idx
+	}
+}
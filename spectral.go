@@ -0,0 +1,942 @@
+package main
+
+/*
+   Kernel k-means and spectral clustering, for clusters that aren't convex,
+   like rings and crescent moons.
+
+   Usage: spectral [-mode spectral|kernel] [options] filename k
+
+   Lloyd's algorithm in km1 draws straight-line boundaries halfway between
+   centroids, so every cluster is a convex Voronoi cell. Both modes here get
+   around that by clustering somewhere other than the x,y plane.
+
+   Kernel k-means runs k-means in the feature space of a kernel function,
+   RBF exp(-gamma*|a-b|^2) or polynomial (a.b + coef0)^degree, using only
+   kernel values. Exact kernel k-means needs the whole n x n kernel matrix,
+   so past -exact points it uses the Nystrom approximation: pick -landmarks
+   random points, and map every point to an explicit feature vector
+   K(x, landmarks) * W^(-1/2), W the kernel matrix among the landmarks.
+   Ordinary k-means then runs on those feature vectors.
+
+   Spectral clustering (Ng, Jordan & Weiss) builds a -knn nearest neighbor
+   graph, weights edges with a self-tuning Gaussian affinity, finds the k
+   eigenvectors of the normalized graph Laplacian with smallest eigenvalues,
+   and runs k-means on the rows of those eigenvectors, scaled to unit length.
+
+   Either way, output is km1's "x y label" format, with the x,y means of
+   the clusters as "x y cN" lines.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+// Vector a point in some higher-dimensional embedding
+type Vector []float64
+
+type dist struct {
+	D2         float64
+	pointIndex int
+}
+
+func main() {
+	mode := flag.String("mode", "spectral", "clustering mode, spectral or kernel")
+	kernelName := flag.String("kernel", "rbf", "kernel k-means kernel, rbf or poly")
+	gamma := flag.Float64("gamma", 0, "RBF kernel gamma, 0 to estimate from the data")
+	degree := flag.Int("degree", 2, "polynomial kernel degree")
+	coef0 := flag.Float64("coef0", 1, "polynomial kernel constant term")
+	exact := flag.Int("exact", 2000, "largest n for exact kernel k-means, Nystrom beyond that")
+	landmarks := flag.Int("landmarks", 200, "Nystrom landmark points")
+	knn := flag.Int("knn", 10, "spectral clustering nearest neighbors per point")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: spectral [-mode spectral|kernel] [options] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	points := readPoints(flag.Arg(0))
+	if k < 1 || k > len(points) {
+		log.Fatalf("k %d out of range for %d points\n", k, len(points))
+	}
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	var labels []int
+
+	switch *mode {
+	case "kernel":
+		var kernel func(a, b Point) float64
+		switch *kernelName {
+		case "rbf":
+			if *gamma <= 0 {
+				*gamma = estimateGamma(points)
+			}
+			g := *gamma
+			kernel = func(a, b Point) float64 {
+				dx := a.x - b.x
+				dy := a.y - b.y
+				return math.Exp(-g * (dx*dx + dy*dy))
+			}
+			fmt.Fprintf(os.Stderr, "# RBF kernel, gamma %g\n", g)
+		case "poly":
+			d, c := float64(*degree), *coef0
+			kernel = func(a, b Point) float64 {
+				return math.Pow(a.x*b.x+a.y*b.y+c, d)
+			}
+			fmt.Fprintf(os.Stderr, "# polynomial kernel, degree %d, coef0 %g\n", *degree, c)
+		default:
+			log.Fatalf("unknown kernel %q, want rbf or poly\n", *kernelName)
+		}
+
+		if len(points) <= *exact {
+			labels = kernelKMeans(k, points, kernel)
+		} else {
+			m := *landmarks
+			if m > len(points) {
+				m = len(points)
+			}
+			fmt.Fprintf(os.Stderr, "# Nystrom approximation, %d landmarks\n", m)
+			labels = kmeanscluster(k, nystromFeatures(points, kernel, m))
+		}
+
+	case "spectral":
+		if *knn < 1 {
+			log.Fatalf("knn %d, want at least 1\n", *knn)
+		}
+		labels = kmeanscluster(k, spectralEmbedding(points, k, *knn))
+
+	default:
+		log.Fatalf("unknown mode %q, want spectral or kernel\n", *mode)
+	}
+
+	clusters := make([][]Point, k)
+	for i, label := range labels {
+		clusters[label] = append(clusters[label], points[i])
+	}
+
+	// Drop empty clusters, which have no mean to print,
+	// and number the rest consecutively.
+	var nonempty [][]Point
+	for _, cluster := range clusters {
+		if len(cluster) > 0 {
+			nonempty = append(nonempty, cluster)
+		}
+	}
+	if len(nonempty) < k {
+		fmt.Fprintf(os.Stderr, "# %d of %d clusters came out empty\n", k-len(nonempty), k)
+	}
+	clusters = nonempty
+
+	for i, centroid := range calcCentroids(clusters) {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+
+	for i, cluster := range clusters {
+		for _, point := range cluster {
+			fmt.Printf("%f %f %d\n", point.x, point.y, i)
+		}
+	}
+}
+
+/*
+Median heuristic: gamma = 1/median squared distance
+over a random sample of pairs of points.
+*/
+func estimateGamma(points []Point) float64 {
+	var d2 []float64
+	for i := 0; i < 2000; i++ {
+		a := points[rand.Intn(len(points))]
+		b := points[rand.Intn(len(points))]
+		dx := a.x - b.x
+		dy := a.y - b.y
+		if d := dx*dx + dy*dy; d > 0 {
+			d2 = append(d2, d)
+		}
+	}
+	if len(d2) == 0 {
+		return 1
+	}
+	sort.Float64s(d2)
+	return 1 / d2[len(d2)/2]
+}
+
+/*
+Exact kernel k-means. The squared feature-space distance from point i
+to the mean of cluster c is
+
+	K(i,i) - 2/|c| sum_{j in c} K(i,j) + 1/|c|^2 sum_{j,l in c} K(j,l)
+
+Starting labels come from k-means++ centroids in the x,y plane.
+*/
+func kernelKMeans(k int, points []Point, kernel func(a, b Point) float64) []int {
+	n := len(points)
+	K := make([][]float64, n)
+	for i := range K {
+		K[i] = make([]float64, n)
+		for j := 0; j <= i; j++ {
+			K[i][j] = kernel(points[i], points[j])
+			K[j][i] = K[i][j]
+		}
+	}
+
+	vectors := make([]Vector, n)
+	for i, point := range points {
+		vectors[i] = Vector{point.x, point.y}
+	}
+	labels := assign(vectors, kMeansPPCentroids(k, vectors))
+
+	for {
+		sizes := make([]float64, k)
+		for _, label := range labels {
+			sizes[label]++
+		}
+
+		// sum over j,l in c of K(j,l), for every cluster c
+		within := make([]float64, k)
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if labels[i] == labels[j] {
+					within[labels[i]] += K[i][j]
+				}
+			}
+		}
+
+		changed := 0
+		newlabels := make([]int, n)
+		for i := 0; i < n; i++ {
+			cross := make([]float64, k)
+			for j := 0; j < n; j++ {
+				cross[labels[j]] += K[i][j]
+			}
+			min := math.Inf(1)
+			for c := 0; c < k; c++ {
+				if sizes[c] == 0 {
+					continue
+				}
+				d := K[i][i] - 2*cross[c]/sizes[c] + within[c]/(sizes[c]*sizes[c])
+				if d < min {
+					min = d
+					newlabels[i] = c
+				}
+			}
+			if newlabels[i] != labels[i] {
+				changed++
+			}
+		}
+
+		labels = newlabels
+		if changed == 0 {
+			break
+		}
+	}
+
+	return labels
+}
+
+/*
+Nystrom features: with C = K(points, landmarks) and W = K(landmarks, landmarks),
+K is approximately C W^-1 C', so the rows of C W^(-1/2) are feature vectors
+whose dot products approximate the kernel.
+*/
+func nystromFeatures(points []Point, kernel func(a, b Point) float64, m int) []Vector {
+	landmarks := make([]Point, m)
+	for i, j := range rand.Perm(len(points))[:m] {
+		landmarks[i] = points[j]
+	}
+
+	W := make([][]float64, m)
+	for i := range W {
+		W[i] = make([]float64, m)
+		for j := range W[i] {
+			W[i][j] = kernel(landmarks[i], landmarks[j])
+		}
+	}
+
+	// W^(-1/2) from the eigendecomposition of W, dropping tiny eigenvalues
+	values, vecs := jacobiEigen(W)
+	invSqrt := make([][]float64, m)
+	for i := range invSqrt {
+		invSqrt[i] = make([]float64, m)
+	}
+	for e, value := range values {
+		if value < 1e-10 {
+			continue
+		}
+		s := 1 / math.Sqrt(value)
+		for i := 0; i < m; i++ {
+			for j := 0; j < m; j++ {
+				invSqrt[i][j] += s * vecs[i][e] * vecs[j][e]
+			}
+		}
+	}
+
+	features := make([]Vector, len(points))
+	c := make([]float64, m)
+	for p, point := range points {
+		for j := range landmarks {
+			c[j] = kernel(point, landmarks[j])
+		}
+		f := make(Vector, m)
+		for j := 0; j < m; j++ {
+			for l := 0; l < m; l++ {
+				f[j] += c[l] * invSqrt[l][j]
+			}
+		}
+		features[p] = f
+	}
+
+	return features
+}
+
+type neighbor struct {
+	index  int
+	weight float64
+}
+
+/*
+Spectral embedding:
+ 1. Connect every point to its knn nearest neighbors, symmetrically.
+ 2. Weight edge i,j with exp(-d^2/(s_i*s_j)), where s_i is the distance
+    from point i to its knn-th nearest neighbor (Zelnik-Manor & Perona).
+ 3. The eigenvectors of the normalized Laplacian I - D^(-1/2) W D^(-1/2)
+    with the k smallest eigenvalues are the eigenvectors of
+    M = D^(-1/2) W D^(-1/2) with the k largest.
+ 4. Scale each row of those k eigenvectors to unit length.
+*/
+func spectralEmbedding(points []Point, k int, knn int) []Vector {
+	n := len(points)
+	if n < 2 {
+		// A lone point has no neighbors, and k must be 1.
+		embedding := make([]Vector, n)
+		for i := range embedding {
+			embedding[i] = make(Vector, k)
+			embedding[i][0] = 1
+		}
+		return embedding
+	}
+	if knn >= n {
+		knn = n - 1
+	}
+
+	tree := newKDTree(points)
+	nearest := make([][]int, n)
+	scale := make([]float64, n)
+	for i := range points {
+		nearest[i] = tree.nearest(i, knn)
+		last := points[nearest[i][len(nearest[i])-1]]
+		scale[i] = math.Max(math.Sqrt(sqDist(points[i], last)), 1e-12)
+	}
+
+	adjacent := make([]map[int]bool, n)
+	for i := range adjacent {
+		adjacent[i] = make(map[int]bool)
+	}
+	for i := range nearest {
+		for _, j := range nearest[i] {
+			adjacent[i][j] = true
+			adjacent[j][i] = true
+		}
+	}
+
+	graph := make([][]neighbor, n)
+	degree := make([]float64, n)
+	for i := range adjacent {
+		for j := range adjacent[i] {
+			w := math.Exp(-sqDist(points[i], points[j]) / (scale[i] * scale[j]))
+			graph[i] = append(graph[i], neighbor{index: j, weight: w})
+			degree[i] += w
+		}
+	}
+	for i := range graph {
+		for e := range graph[i] {
+			j := graph[i][e].index
+			graph[i][e].weight /= math.Sqrt(degree[i] * degree[j])
+		}
+	}
+
+	eigenvectors := topEigenvectors(graph, k)
+
+	embedding := make([]Vector, n)
+	for i := range embedding {
+		v := make(Vector, k)
+		norm := 0.0
+		for c := 0; c < k; c++ {
+			v[c] = eigenvectors[c][i]
+			norm += v[c] * v[c]
+		}
+		norm = math.Sqrt(norm)
+		if norm > 0 {
+			for c := range v {
+				v[c] /= norm
+			}
+		}
+		embedding[i] = v
+	}
+
+	return embedding
+}
+
+/*
+2-d tree of point indexes, for the nearest neighbor queries. Each node
+splits on x or y, alternating with depth, at the median of the points
+below it.
+*/
+type kdTree struct {
+	points []Point
+	root   *kdNode
+}
+
+type kdNode struct {
+	index       int
+	axis        int
+	left, right *kdNode
+}
+
+func newKDTree(points []Point) *kdTree {
+	indexes := make([]int, len(points))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	t := &kdTree{points: points}
+	t.root = t.build(indexes, 0)
+	return t
+}
+
+func (t *kdTree) build(indexes []int, depth int) *kdNode {
+	if len(indexes) == 0 {
+		return nil
+	}
+	axis := depth % 2
+	sort.Slice(indexes, func(i, j int) bool {
+		return coord(t.points[indexes[i]], axis) < coord(t.points[indexes[j]], axis)
+	})
+	mid := len(indexes) / 2
+	return &kdNode{
+		index: indexes[mid],
+		axis:  axis,
+		left:  t.build(indexes[:mid], depth+1),
+		right: t.build(indexes[mid+1:], depth+1),
+	}
+}
+
+func coord(p Point, axis int) float64 {
+	if axis == 0 {
+		return p.x
+	}
+	return p.y
+}
+
+// Indexes of the knn points nearest points[i], not counting itself, nearest first.
+func (t *kdTree) nearest(i int, knn int) []int {
+	p := t.points[i]
+	var found []int
+	var dists []float64
+
+	var search func(nd *kdNode)
+	search = func(nd *kdNode) {
+		if nd == nil {
+			return
+		}
+		if nd.index != i {
+			d := sqDist(p, t.points[nd.index])
+			if len(found) < knn || d < dists[len(dists)-1] {
+				at := sort.SearchFloat64s(dists, d)
+				found = append(found[:at], append([]int{nd.index}, found[at:]...)...)
+				dists = append(dists[:at], append([]float64{d}, dists[at:]...)...)
+				if len(found) > knn {
+					found = found[:knn]
+					dists = dists[:knn]
+				}
+			}
+		}
+
+		diff := coord(p, nd.axis) - coord(t.points[nd.index], nd.axis)
+		near, far := nd.left, nd.right
+		if diff > 0 {
+			near, far = far, near
+		}
+		search(near)
+		if len(found) < knn || diff*diff < dists[len(dists)-1] {
+			search(far)
+		}
+	}
+	search(t.root)
+	return found
+}
+
+/*
+Subspace iteration for the k eigenvectors of the sparse symmetric
+matrix M with largest eigenvalues. M's eigenvalues lie in [-1, 1],
+so iterate with A = M + I to keep them all in [0, 2]. Every step
+rotates a block of k + oversample vectors to the Rayleigh-Ritz
+eigenvectors of A, then runs them through a Chebyshev polynomial
+in A that stays small on [0, cut], cut the smallest Ritz value,
+and grows fast above it (Zhou & Saad). Near-equal eigenvalues at the
+top of a big neighbor graph make plain powers of A crawl; the
+polynomial pulls them apart about as fast as powers of A would if
+the gap were its square root.
+
+Stops once every top k Ritz pair has |Au - theta*u| <= 1e-6*theta,
+or once the span of the top k stops moving. Past maxIterations it
+warns and returns the last Ritz vectors, still a fair stand-in for
+k-means when eigenvalues crowd together with no real clusters.
+*/
+func topEigenvectors(graph [][]neighbor, k int) [][]float64 {
+	const (
+		maxIterations = 200
+		oversample    = 10
+		degree        = 8
+		tolerance     = 1e-6
+	)
+
+	n := len(graph)
+	b := k + oversample
+	if b > n {
+		b = n
+	}
+
+	Q := make([][]float64, b)
+	for c := range Q {
+		Q[c] = make([]float64, n)
+		for i := range Q[c] {
+			Q[c][i] = rand.Float64() - 0.5
+		}
+	}
+	orthonormalize(Q)
+
+	multiply := func(v []float64) []float64 {
+		out := make([]float64, n)
+		for i := range graph {
+			out[i] = v[i]
+			for _, e := range graph[i] {
+				out[i] += e.weight * v[e.index]
+			}
+		}
+		return out
+	}
+
+	// combine returns the linear combination of vs with weights column e of vecs
+	combine := func(vs [][]float64, vecs [][]float64, e int) []float64 {
+		out := make([]float64, n)
+		for a := range vs {
+			w := vecs[a][e]
+			for i := range out {
+				out[i] += w * vs[a][i]
+			}
+		}
+		return out
+	}
+
+	var top [][]float64 // the top k Ritz vectors of the last step
+	for iter := 0; iter < maxIterations; iter++ {
+		Z := make([][]float64, b)
+		for c := range Q {
+			Z[c] = multiply(Q[c])
+		}
+
+		// Rayleigh-Ritz: rotate the block to the eigenvectors of Q'(M+I)Q
+		H := make([][]float64, b)
+		for a := range H {
+			H[a] = make([]float64, b)
+			for c := range H[a] {
+				H[a][c] = dot(Q[a], Z[c])
+			}
+		}
+		values, vecs := jacobiEigen(H)
+		order := make([]int, b)
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool { return values[order[i]] > values[order[j]] })
+
+		// Residual |(M+I)u - theta*u| of the top k Ritz vectors u = Q*v
+		converged := true
+		ritz := make([][]float64, b)
+		next := make([][]float64, b)
+		for c, e := range order {
+			ritz[c] = combine(Q, vecs, e)
+			next[c] = combine(Z, vecs, e)
+			if c >= k {
+				continue
+			}
+			residual := 0.0
+			for i := range ritz[c] {
+				r := next[c][i] - values[e]*ritz[c][i]
+				residual += r * r
+			}
+			if math.Sqrt(residual) > tolerance*math.Abs(values[e]) {
+				converged = false
+			}
+		}
+
+		// How far the top k span moved: k minus the squared
+		// projections of the new top k onto the old.
+		if !converged && top != nil {
+			moved := float64(k)
+			for c := 0; c < k; c++ {
+				for _, old := range top {
+					d := dot(ritz[c], old)
+					moved -= d * d
+				}
+			}
+			converged = moved < tolerance*tolerance
+		}
+
+		if converged {
+			return ritz[:k]
+		}
+
+		top = ritz[:k]
+
+		// Chebyshev filter: T_m((A - c)/e) maps [0, cut] into [-1, 1].
+		// next already holds A times every Ritz vector.
+		cut := math.Max(values[order[b-1]], 1e-3)
+		c, e := cut/2, cut/2
+		for v := range ritz {
+			prev, cur := ritz[v], make([]float64, n)
+			for i := range cur {
+				cur[i] = (next[v][i] - c*prev[i]) / e
+			}
+			for m := 2; m <= degree; m++ {
+				Acur := multiply(cur)
+				for i := range Acur {
+					Acur[i] = 2*(Acur[i]-c*cur[i])/e - prev[i]
+				}
+				prev, cur = cur, Acur
+			}
+			next[v] = cur
+		}
+
+		// Twice, since the filter leaves the block nearly dependent.
+		orthonormalize(next)
+		orthonormalize(next)
+		Q = next
+	}
+
+	fmt.Fprintf(os.Stderr, "# eigenvectors didn't converge in %d iterations, using the last ones\n", maxIterations)
+
+	return top
+}
+
+// Modified Gram-Schmidt, in place.
+func orthonormalize(vs [][]float64) {
+	for c := range vs {
+		for p := 0; p < c; p++ {
+			d := dot(vs[c], vs[p])
+			for i := range vs[c] {
+				vs[c][i] -= d * vs[p][i]
+			}
+		}
+		norm := math.Sqrt(dot(vs[c], vs[c]))
+		if norm == 0 {
+			continue
+		}
+		for i := range vs[c] {
+			vs[c][i] /= norm
+		}
+	}
+}
+
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+/*
+Eigenvalues and eigenvectors of a small symmetric matrix by cyclic
+Jacobi rotations. Eigenvector e is column e of the returned matrix.
+*/
+func jacobiEigen(matrix [][]float64) ([]float64, [][]float64) {
+	n := len(matrix)
+	a := make([][]float64, n)
+	v := make([][]float64, n)
+	for i := range a {
+		a[i] = append([]float64(nil), matrix[i]...)
+		v[i] = make([]float64, n)
+		v[i][i] = 1
+	}
+
+	for sweep := 0; sweep < 100; sweep++ {
+		off := 0.0
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				off += a[i][j] * a[i][j]
+			}
+		}
+		if off < 1e-22 {
+			break
+		}
+
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if math.Abs(a[p][q]) < 1e-300 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				for i := 0; i < n; i++ {
+					aip, aiq := a[i][p], a[i][q]
+					a[i][p] = c*aip - s*aiq
+					a[i][q] = s*aip + c*aiq
+				}
+				for i := 0; i < n; i++ {
+					api, aqi := a[p][i], a[q][i]
+					a[p][i] = c*api - s*aqi
+					a[q][i] = s*api + c*aqi
+				}
+				for i := 0; i < n; i++ {
+					vip, viq := v[i][p], v[i][q]
+					v[i][p] = c*vip - s*viq
+					v[i][q] = s*vip + c*viq
+				}
+			}
+		}
+	}
+
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = a[i][i]
+	}
+	return values, v
+}
+
+/*
+Lloyd's algorithm, same as km1's kmeanscluster, but on vectors of
+any dimension, and returning each vector's cluster instead of printing.
+*/
+func kmeanscluster(k int, vectors []Vector) []int {
+
+	centroids := kMeansPPCentroids(k, vectors)
+
+	looping := true
+
+	var labels []int
+
+	for looping {
+		labels = assign(vectors, centroids)
+		newcentroids := calcVectorCentroids(k, vectors, labels, centroids)
+		looping = compareCentroids(centroids, newcentroids)
+		centroids = newcentroids
+	}
+
+	return labels
+}
+
+func assign(vectors []Vector, centroids []Vector) []int {
+	labels := make([]int, len(vectors))
+	for i, v := range vectors {
+		min := math.Inf(1)
+		for c, centroid := range centroids {
+			if d := sqDistance(v, centroid); d < min {
+				min = d
+				labels[i] = c
+			}
+		}
+	}
+	return labels
+}
+
+// An empty cluster keeps its old centroid.
+func calcVectorCentroids(k int, vectors []Vector, labels []int, old []Vector) []Vector {
+	dim := len(vectors[0])
+	centroids := make([]Vector, k)
+	counts := make([]float64, k)
+	for c := range centroids {
+		centroids[c] = make(Vector, dim)
+	}
+	for i, v := range vectors {
+		counts[labels[i]]++
+		for d := range v {
+			centroids[labels[i]][d] += v[d]
+		}
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			centroids[c] = old[c]
+			continue
+		}
+		for d := range centroids[c] {
+			centroids[c][d] /= counts[c]
+		}
+	}
+	return centroids
+}
+
+func compareCentroids(centroids []Vector, newcentroids []Vector) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		if sqDistance(centroids[i], newcentroids[i]) > 1e-12 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+func sqDistance(a, b Vector) float64 {
+	sum := 0.0
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+/*
+		k-means++ method of finding initial guesses at centroids.
+
+	 1. Choose one center uniformly at random among the data points.
+	 2. For each data point x, compute D(x), the distance between x and the nearest
+	    center that has already been chosen.
+	 3. Choose one new data point at random as a new center, using a weighted
+	    probability distribution where a point x is chosen with probability
+	    proportional to D(x)^2.
+	 4. Repeat Steps 2 and 3 until k centers have been chosen.
+*/
+func kMeansPPCentroids(k int, vectors []Vector) (centroids []Vector) {
+
+	centroids = append(centroids, vectors[rand.Intn(len(vectors))])
+
+	D := make([]dist, len(vectors))
+
+	for i := 0; i < k-1; i++ {
+		fillDistances(D, vectors, centroids)
+		newCenterIndex := weightedChoice(D)
+		centroids = append(centroids, vectors[newCenterIndex])
+	}
+
+	return
+}
+
+type interval struct {
+	maxval float64
+	index  int
+}
+
+/*
+Choose one new data point at random as a new center, using a weighted
+probability distribution where a point x is chosen with probability
+proportional to D(x)^2.
+*/
+func weightedChoice(D []dist) int {
+	sumValues := 0.0
+
+	var intervals []interval
+
+	for i := range D {
+		sumValues += D[i].D2
+		intervals = append(intervals, interval{maxval: sumValues, index: D[i].pointIndex})
+	}
+
+	inInterval := rand.Float64() * sumValues
+
+	for i := range intervals {
+		if inInterval < intervals[i].maxval {
+			return intervals[i].index
+		}
+	}
+
+	return 0
+}
+
+/*
+For each data point x, compute D(x)^2, the squared distance between x
+and the nearest center that has already been chosen.
+*/
+func fillDistances(D []dist, vectors []Vector, centroids []Vector) {
+
+	for idx, v := range vectors {
+		minD := sqDistance(v, centroids[0])
+
+		for _, center := range centroids {
+			if d := sqDistance(v, center); d < minD {
+				minD = d
+			}
+		}
+
+		D[idx].D2 = minD
+		D[idx].pointIndex = idx
+	}
+}
+
+func calcCentroids(clusters [][]Point) []Point {
+	centroids := make([]Point, len(clusters))
+
+	for cent, cluster := range clusters {
+		var sumx, sumy float64
+		for _, point := range cluster {
+			sumx += point.x
+			sumy += point.y
+		}
+		centroids[cent] = Point{x: sumx / float64(len(cluster)), y: sumy / float64(len(cluster))}
+	}
+
+	return centroids
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
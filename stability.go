@@ -0,0 +1,489 @@
+package main
+
+/*
+   Is a k-means clustering real structure, or an accident of the random
+   initial centroids? Cluster bootstrap resamples and see what survives.
+
+   Usage: stability [-runs B] [-kmin N] [-kmax N] [-matrix file] filename k
+
+   First kmeanscluster clusters all the points into k reference clusters.
+   Then B times over, it clusters a bootstrap resample: n points drawn with
+   replacement. Each reference cluster, cut down to the points that made it
+   into the resample, gets matched to the resample cluster it has the best
+   Jaccard similarity with, |A and B| / |A or B| (Hennig's clusterboot).
+
+   Prints:
+   - each reference cluster's mean Jaccard similarity over the runs.
+     Above 0.75 or so is stable, below 0.5 the cluster dissolves.
+   - a k by k co-assignment matrix: for reference clusters a and b, the
+     fraction of point pairs, one from a and one from b, that landed in
+     the same cluster in resamples where both appeared. Stable clusters
+     have 1 on the diagonal, 0 off it.
+   - with -kmin and -kmax, mean and minimum Jaccard stability for every
+     k in that range, a stability versus k curve.
+
+   -matrix writes the full point-by-point co-assignment matrix, "i j
+   fraction" for every pair that appeared together at least once, with
+   i and j line numbers, counting from 0, in filename.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+type dist struct {
+	D2         float64
+	pointIndex int
+}
+
+func main() {
+	runs := flag.Int("runs", 100, "bootstrap resamples")
+	kmin := flag.Int("kmin", 0, "smallest k for the stability curve")
+	kmax := flag.Int("kmax", 0, "largest k for the stability curve")
+	matrixFile := flag.String("matrix", "", "write point co-assignment matrix to this file")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: stability [-runs B] [-kmin N] [-kmax N] [-matrix file] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	points := readPoints(flag.Arg(0))
+	if k < 1 || k > len(points) {
+		log.Fatalf("k %d out of range for %d points\n", k, len(points))
+	}
+	if *runs < 1 {
+		log.Fatalf("runs %d must be at least 1\n", *runs)
+	}
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	var pairs *pairCounts
+	if *matrixFile != "" {
+		if *runs > math.MaxUint16 {
+			log.Fatalf("-matrix counts only go to %d runs\n", math.MaxUint16)
+		}
+		pairs = newPairCounts(len(points))
+	}
+
+	s := bootstrap(points, k, *runs, pairs)
+
+	fmt.Printf("k = %d, %d points, %d bootstrap runs\n\n", k, len(points), *runs)
+	fmt.Printf("%7s %6s %8s %8s %9s\n", "cluster", "size", "jaccard", "min", "dissolved")
+	for c := 0; c < k; c++ {
+		fmt.Printf("%7d %6d %8.4f %8.4f %9d\n", c, s.sizes[c], s.jaccard[c], s.minJaccard[c], s.dissolved[c])
+	}
+
+	fmt.Printf("\nco-assignment between reference clusters\n%7s", "")
+	for b := 0; b < k; b++ {
+		fmt.Printf(" %7d", b)
+	}
+	fmt.Printf("\n")
+	for a := 0; a < k; a++ {
+		fmt.Printf("%7d", a)
+		for b := 0; b < k; b++ {
+			fmt.Printf(" %7.4f", s.coassign[a][b])
+		}
+		fmt.Printf("\n")
+	}
+
+	if *kmin > 0 && *kmax >= *kmin {
+		fmt.Printf("\nstability versus k\n%4s %8s %8s\n", "k", "mean", "min")
+		for kk := *kmin; kk <= *kmax && kk <= len(points); kk++ {
+			curve := bootstrap(points, kk, *runs, nil)
+			mean, min := 0.0, math.Inf(1)
+			for _, j := range curve.jaccard {
+				mean += j
+				min = math.Min(min, j)
+			}
+			fmt.Printf("%4d %8.4f %8.4f\n", kk, mean/float64(kk), min)
+		}
+	}
+
+	if pairs != nil {
+		pairs.write(*matrixFile)
+	}
+}
+
+type stability struct {
+	sizes      []int
+	jaccard    []float64 // mean over runs
+	minJaccard []float64
+	dissolved  []int // runs with Jaccard below 0.5
+	coassign   [][]float64
+}
+
+func bootstrap(points []Point, k int, runs int, pairs *pairCounts) *stability {
+	n := len(points)
+	reference := kmeanscluster(k, points)
+
+	s := &stability{
+		sizes:      make([]int, k),
+		jaccard:    make([]float64, k),
+		minJaccard: make([]float64, k),
+		dissolved:  make([]int, k),
+		coassign:   make([][]float64, k),
+	}
+	for c := range s.minJaccard {
+		s.minJaccard[c] = 1
+	}
+	for _, l := range reference {
+		s.sizes[l]++
+	}
+
+	coSum := make([][]float64, k)
+	coRuns := make([][]float64, k)
+	for a := range coSum {
+		coSum[a] = make([]float64, k)
+		coRuns[a] = make([]float64, k)
+		s.coassign[a] = make([]float64, k)
+	}
+
+	for run := 0; run < runs; run++ {
+		// Draw with replacement, but cluster and compare the distinct points.
+		inSample := make([]bool, n)
+		for i := 0; i < n; i++ {
+			inSample[rand.Intn(n)] = true
+		}
+		var sample []int
+		var resample []Point
+		for i := range inSample {
+			if inSample[i] {
+				sample = append(sample, i)
+				resample = append(resample, points[i])
+			}
+		}
+
+		kk := k
+		if kk > len(resample) {
+			kk = len(resample)
+		}
+		labels := kmeanscluster(kk, resample)
+
+		// overlap[a][b] counts sampled points in reference cluster a, resample cluster b
+		overlap := make([][]float64, k)
+		for a := range overlap {
+			overlap[a] = make([]float64, kk)
+		}
+		refSize := make([]float64, k)
+		newSize := make([]float64, kk)
+		for j, i := range sample {
+			overlap[reference[i]][labels[j]]++
+			refSize[reference[i]]++
+			newSize[labels[j]]++
+		}
+
+		for a := 0; a < k; a++ {
+			best := 0.0
+			if refSize[a] > 0 {
+				for b := 0; b < kk; b++ {
+					union := refSize[a] + newSize[b] - overlap[a][b]
+					best = math.Max(best, overlap[a][b]/union)
+				}
+			}
+			s.jaccard[a] += best
+			s.minJaccard[a] = math.Min(s.minJaccard[a], best)
+			if best < 0.5 {
+				s.dissolved[a]++
+			}
+
+			for b := 0; b < k; b++ {
+				var together, total float64
+				if a == b {
+					// pairs of distinct points within cluster a
+					for c := 0; c < kk; c++ {
+						together += overlap[a][c] * (overlap[a][c] - 1)
+					}
+					total = refSize[a] * (refSize[a] - 1)
+				} else {
+					for c := 0; c < kk; c++ {
+						together += overlap[a][c] * overlap[b][c]
+					}
+					total = refSize[a] * refSize[b]
+				}
+				if total > 0 {
+					coSum[a][b] += together / total
+					coRuns[a][b]++
+				}
+			}
+		}
+
+		if pairs != nil {
+			pairs.add(sample, labels)
+		}
+	}
+
+	for a := 0; a < k; a++ {
+		s.jaccard[a] /= float64(runs)
+		for b := 0; b < k; b++ {
+			if coRuns[a][b] > 0 {
+				s.coassign[a][b] = coSum[a][b] / coRuns[a][b]
+			}
+		}
+	}
+
+	return s
+}
+
+/*
+Point-by-point co-assignment counts, kept as a triangular matrix of
+how often each pair appeared in the same resample, and how often
+they landed in the same cluster. That's n^2 counts, so only on request.
+*/
+type pairCounts struct {
+	n        int
+	sampled  []uint16
+	together []uint16
+}
+
+func newPairCounts(n int) *pairCounts {
+	size := n * (n - 1) / 2
+	return &pairCounts{n: n, sampled: make([]uint16, size), together: make([]uint16, size)}
+}
+
+func (p *pairCounts) index(i, j int) int {
+	return p.n*i - i*(i+1)/2 + j - i - 1
+}
+
+// sample holds increasing point indexes, labels their clusters in this run.
+func (p *pairCounts) add(sample []int, labels []int) {
+	for a := range sample {
+		for b := a + 1; b < len(sample); b++ {
+			idx := p.index(sample[a], sample[b])
+			p.sampled[idx]++
+			if labels[a] == labels[b] {
+				p.together[idx]++
+			}
+		}
+	}
+}
+
+func (p *pairCounts) write(filename string) {
+	fout, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(fout)
+	for i := 0; i < p.n; i++ {
+		for j := i + 1; j < p.n; j++ {
+			idx := p.index(i, j)
+			if p.sampled[idx] > 0 {
+				fmt.Fprintf(w, "%d %d %f\n", i, j, float64(p.together[idx])/float64(p.sampled[idx]))
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := fout.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+/*
+Lloyd's algorithm from k-means++ initial centroids, as in km1a,
+returning the cluster of every point.
+*/
+func kmeanscluster(k int, points []Point) []int {
+
+	centroids := kMeansPPCentroids(k, points)
+
+	looping := true
+
+	labels := make([]int, len(points))
+
+	for looping {
+		for i, point := range points {
+			labels[i] = nearestCentroid(point, centroids)
+		}
+
+		newcentroids := calcCentroids(k, points, labels, centroids)
+		looping = compareCentroids(centroids, newcentroids)
+		centroids = newcentroids
+	}
+
+	return labels
+}
+
+func nearestCentroid(point Point, centroids []Point) int {
+	min := math.Inf(1)
+	cent := 0
+	for i, centroid := range centroids {
+		dx := centroid.x - point.x
+		dy := centroid.y - point.y
+		if d := dx*dx + dy*dy; d < min {
+			min = d
+			cent = i
+		}
+	}
+	return cent
+}
+
+func compareCentroids(centroids []Point, newcentroids []Point) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		dx := centroids[i].x - newcentroids[i].x
+		dy := centroids[i].y - newcentroids[i].y
+		dist := dx*dx + dy*dy
+		if dist > 0.01 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+// An empty cluster keeps its old centroid.
+func calcCentroids(k int, points []Point, labels []int, old []Point) []Point {
+	centroids := make([]Point, k)
+	counts := make([]float64, k)
+
+	for i, point := range points {
+		centroids[labels[i]].x += point.x
+		centroids[labels[i]].y += point.y
+		counts[labels[i]]++
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			centroids[c] = old[c]
+			continue
+		}
+		centroids[c].x /= counts[c]
+		centroids[c].y /= counts[c]
+	}
+
+	return centroids
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
+
+/*
+		k-means++ method of finding initial guesses at centroids.
+
+	 1. Choose one center uniformly at random among the data points.
+	 2. For each data point x, compute D(x), the distance between x and the nearest
+	    center that has already been chosen.
+	 3. Choose one new data point at random as a new center, using a weighted
+	    probability distribution where a point x is chosen with probability
+	    proportional to D(x)^2.
+	 4. Repeat Steps 2 and 3 until k centers have been chosen.
+*/
+func kMeansPPCentroids(k int, points []Point) (centroids []Point) {
+
+	centroids = append(centroids, points[rand.Intn(len(points))])
+
+	D := make([]dist, len(points))
+
+	for i := 0; i < k-1; i++ {
+		fillDistances(D, points, centroids)
+		newCenterIndex := weightedChoice(D)
+		centroids = append(centroids, points[newCenterIndex])
+	}
+
+	return
+}
+
+type interval struct {
+	maxval float64
+	index  int
+}
+
+/*
+Choose one new data point at random as a new center, using a weighted
+probability distribution where a point x is chosen with probability
+proportional to D(x)^2.
+*/
+func weightedChoice(D []dist) int {
+	sumValues := 0.0
+
+	var intervals []interval
+
+	for i := range D {
+		sumValues += D[i].D2
+		intervals = append(intervals, interval{maxval: sumValues, index: D[i].pointIndex})
+	}
+
+	inInterval := rand.Float64() * sumValues
+
+	for i := range intervals {
+		if inInterval < intervals[i].maxval {
+			return intervals[i].index
+		}
+	}
+
+	return 0
+}
+
+/*
+For each data point x, compute D(x)^2, the squared distance between x
+and the nearest center that has already been chosen.
+*/
+func fillDistances(D []dist, points []Point, centroids []Point) {
+
+	for idx, point := range points {
+		dx := point.x - centroids[0].x
+		dy := point.y - centroids[0].y
+		minD := dx*dx + dy*dy
+
+		for _, center := range centroids {
+			dx := point.x - center.x
+			dy := point.y - center.y
+			d := dx*dx + dy*dy
+			if d < minD {
+				minD = d
+			}
+		}
+
+		D[idx].D2 = minD
+		D[idx].pointIndex = idx
+	}
+}
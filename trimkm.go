@@ -0,0 +1,299 @@
+package main
+
+/*
+   Trimmed k-means, for data with outliers.
+
+   Usage: trimkm [-alpha a] [-threshold t] [-scores file] filename k
+
+   A few far-away points drag km1's centroids, because calcCentroids
+   averages in every point of a cluster. Trimmed k-means (Cuesta-Albertos,
+   Gordaliza & Matran) assigns every point to its nearest centroid as
+   usual, but leaves the alpha fraction of points farthest from their
+   centroids out of the centroid update, every iteration.
+
+   Each point also gets an outlier score: its distance to its centroid,
+   divided by the cluster's spread, the root mean square distance of the
+   cluster's untrimmed points to the centroid. A score of 3 means three
+   times as far out as typical for that cluster.
+
+   Points trimmed in the last iteration get flagged as outliers, as do
+   points scoring over -threshold, if given. Outliers get label -1, like
+   dbscan's noise, so `grep ' -1$'` plots them in their own color.
+
+   Output is km1's format, outliers last. -scores writes
+   "x y label score" for every point, label being the nearest cluster
+   even for outliers.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+const outlier = -1
+
+func main() {
+	alpha := flag.Float64("alpha", 0.05, "fraction of points to trim from centroid updates")
+	threshold := flag.Float64("threshold", 0, "also flag points with outlier score above this, 0 for off")
+	scoresFile := flag.String("scores", "", "write \"x y label score\" for every point to this file")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: trimkm [-alpha a] [-threshold t] [-scores file] filename k")
+	}
+
+	k, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	points := readPoints(flag.Arg(0))
+	if *alpha < 0 || *alpha >= 1 {
+		log.Fatalf("alpha %f out of range [0, 1)\n", *alpha)
+	}
+	trim := int(math.Floor(*alpha * float64(len(points))))
+	if k < 1 || k > len(points)-trim {
+		log.Fatalf("k %d out of range for %d points, %d trimmed\n", k, len(points), trim)
+	}
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	centroids, labels, trimmed, iterations := trimmedkmeans(k, points, trim)
+	scores := outlierScores(points, centroids, labels, trimmed)
+
+	flagged := make([]bool, len(points))
+	nflagged := 0
+	for i := range points {
+		flagged[i] = trimmed[i] || (*threshold > 0 && scores[i] > *threshold)
+		if flagged[i] {
+			nflagged++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "# %d iterations, %d trimmed (alpha %f), %d flagged as outliers\n",
+		iterations, trim, *alpha, nflagged)
+
+	for i, centroid := range centroids {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+	for c := range centroids {
+		for i, point := range points {
+			if labels[i] == c && !flagged[i] {
+				fmt.Printf("%f %f %d\n", point.x, point.y, c)
+			}
+		}
+	}
+	for i, point := range points {
+		if flagged[i] {
+			fmt.Printf("%f %f %d\n", point.x, point.y, outlier)
+		}
+	}
+
+	if *scoresFile != "" {
+		fout, err := os.Create(*scoresFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := bufio.NewWriter(fout)
+		for i, point := range points {
+			fmt.Fprintf(w, "%f %f %d %f\n", point.x, point.y, labels[i], scores[i])
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		if err := fout.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+/*
+Lloyd's algorithm, except that the trim points farthest from their
+nearest centroid don't count toward the new centroids. Returns the
+centroids, every point's nearest centroid, which points got trimmed
+in the final iteration, and the number of iterations.
+*/
+func trimmedkmeans(k int, points []Point, trim int) ([]Point, []int, []bool, int) {
+
+	centroids := randomCentroids(k, points)
+
+	labels := make([]int, len(points))
+	distances := make([]float64, len(points))
+	trimmed := make([]bool, len(points))
+	order := make([]int, len(points))
+
+	looping := true
+	iterations := 0
+
+	for looping {
+		iterations++
+
+		for i, point := range points {
+			labels[i], distances[i] = nearestCentroid(point, centroids)
+			order[i] = i
+			trimmed[i] = false
+		}
+		sort.Slice(order, func(a, b int) bool { return distances[order[a]] > distances[order[b]] })
+		for _, i := range order[:trim] {
+			trimmed[i] = true
+		}
+
+		newcentroids := calcCentroids(k, points, labels, trimmed, centroids)
+		looping = compareCentroids(centroids, newcentroids)
+		centroids = newcentroids
+	}
+
+	return centroids, labels, trimmed, iterations
+}
+
+// Index of, and squared distance to, the nearest centroid.
+func nearestCentroid(point Point, centroids []Point) (int, float64) {
+	min := math.Inf(1)
+	cent := 0
+	for i, centroid := range centroids {
+		if d := sqDist(point, centroid); d < min {
+			min = d
+			cent = i
+		}
+	}
+	return cent, min
+}
+
+// Means of the untrimmed points of each cluster. A cluster
+// left with no untrimmed points keeps its old centroid.
+func calcCentroids(k int, points []Point, labels []int, trimmed []bool, old []Point) []Point {
+	centroids := make([]Point, k)
+	counts := make([]float64, k)
+
+	for i, point := range points {
+		if trimmed[i] {
+			continue
+		}
+		centroids[labels[i]].x += point.x
+		centroids[labels[i]].y += point.y
+		counts[labels[i]]++
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			centroids[c] = old[c]
+			continue
+		}
+		centroids[c].x /= counts[c]
+		centroids[c].y /= counts[c]
+	}
+
+	return centroids
+}
+
+/*
+Distance to the nearest centroid over the cluster's spread, the root
+mean square distance of its untrimmed points. A cluster with no spread
+scores its points by raw distance.
+*/
+func outlierScores(points []Point, centroids []Point, labels []int, trimmed []bool) []float64 {
+	sumsq := make([]float64, len(centroids))
+	counts := make([]float64, len(centroids))
+	for i, point := range points {
+		if trimmed[i] {
+			continue
+		}
+		sumsq[labels[i]] += sqDist(point, centroids[labels[i]])
+		counts[labels[i]]++
+	}
+
+	scores := make([]float64, len(points))
+	for i, point := range points {
+		c := labels[i]
+		spread := 1.0
+		if counts[c] > 0 && sumsq[c] > 0 {
+			spread = math.Sqrt(sumsq[c] / counts[c])
+		}
+		scores[i] = math.Sqrt(sqDist(point, centroids[c])) / spread
+	}
+	return scores
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return dx*dx + dy*dy
+}
+
+func compareCentroids(centroids []Point, newcentroids []Point) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		dx := centroids[i].x - newcentroids[i].x
+		dy := centroids[i].y - newcentroids[i].y
+		dist := dx*dx + dy*dy
+		if dist > 0.01 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
+
+func randomCentroids(k int, points []Point) (centroids []Point) {
+
+	for len(centroids) < k {
+		candidate := points[rand.Intn(len(points))]
+		foundit := false
+		for _, centroid := range centroids {
+			if candidate.x == centroid.x && candidate.y == centroid.y {
+				foundit = true
+				break
+			}
+		}
+		if !foundit {
+			centroids = append(centroids, candidate)
+		}
+	}
+
+	return
+}
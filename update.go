@@ -0,0 +1,367 @@
+package main
+
+/*
+   Update a clustering as points come and go, without starting over
+   from a fresh k-means++ seeding that reshuffles every label.
+
+   Usage: update [-add file] [-remove file] [-save file] [-points file] modelfile [filename]
+
+   filename holds the points the model was trained on, and defaults to
+   the file named in the model. Points in the -remove file come out of
+   that set, matched by exact coordinates, and points in the -add file
+   go in. Lloyd's algorithm then runs starting from the model's centroids,
+   so clusters keep their labels and only move as far as the data does.
+
+   Every point in both the old and new sets that ended up with a different
+   label goes to stderr as a "# changed x y oldlabel newlabel" line, followed
+   by a count. The new clustering goes to stdout in km1's format, and the
+   -save file gets the updated model.
+
+   The saved model names the file its points came from, so the next update
+   can start from it. After -add or -remove that's no longer filename,
+   so -save needs -points too, which writes the new point set.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+// Model is what a trained clustering saves, so that predict can
+// label new points without re-clustering.
+type Model struct {
+	Dimension int         `json:"dimension"`
+	Metric    string      `json:"metric"`
+	Scaling   Scaling     `json:"scaling"`
+	Centroids [][]float64 `json:"centroids"`
+	Training  Training    `json:"training"`
+}
+
+// Scaling maps a raw coordinate v to (v - Center)/Scale,
+// per dimension, before measuring distance to centroids.
+type Scaling struct {
+	Method string    `json:"method"`
+	Center []float64 `json:"center"`
+	Scale  []float64 `json:"scale"`
+}
+
+// Training metadata, for people reading the model file.
+type Training struct {
+	File       string    `json:"file"`
+	Points     int       `json:"points"`
+	K          int       `json:"k"`
+	Iterations int       `json:"iterations"`
+	SSE        float64   `json:"sse"`
+	Sizes      []int     `json:"sizes"`
+	Trained    time.Time `json:"trained"`
+}
+
+func main() {
+	addFile := flag.String("add", "", "file of points to add")
+	removeFile := flag.String("remove", "", "file of points to remove")
+	saveFile := flag.String("save", "", "save the updated model to this file")
+	pointsFile := flag.String("points", "", "write the updated point set to this file")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: update [-add file] [-remove file] [-save file] [-points file] modelfile [filename]")
+	}
+	if *saveFile != "" && *pointsFile == "" && (*addFile != "" || *removeFile != "") {
+		log.Fatal("-save after -add or -remove needs -points, for the model to record the new point set")
+	}
+
+	model := readModel(flag.Arg(0))
+
+	filename := model.Training.File
+	if flag.NArg() > 1 {
+		filename = flag.Arg(1)
+	}
+	oldPoints := readPoints(filename)
+
+	// Points to drop, by coordinates, counting duplicates.
+	removals := make(map[Point]int)
+	if *removeFile != "" {
+		for _, p := range readPoints(*removeFile) {
+			removals[p]++
+		}
+	}
+
+	oldLabels := make(map[Point][]int)
+	var points []Point
+	removed := 0
+	for _, p := range oldPoints {
+		label := model.nearest(model.scale(p))
+		if removals[p] > 0 {
+			removals[p]--
+			removed++
+			continue
+		}
+		oldLabels[p] = append(oldLabels[p], label)
+		points = append(points, p)
+	}
+	for p, count := range removals {
+		if count > 0 {
+			log.Printf("%d copies of %f %f not in %s, can't remove them\n", count, p.x, p.y, filename)
+		}
+	}
+
+	added := 0
+	if *addFile != "" {
+		for _, p := range readPoints(*addFile) {
+			points = append(points, p)
+			added++
+		}
+	}
+	if len(points) == 0 {
+		log.Fatal("no points left to cluster")
+	}
+
+	labels, iterations := model.lloyd(points)
+
+	changed := 0
+	for i, p := range points {
+		olds := oldLabels[p]
+		if len(olds) == 0 {
+			continue // an added point
+		}
+		oldLabel := olds[0]
+		oldLabels[p] = olds[1:]
+		if oldLabel != labels[i] {
+			fmt.Fprintf(os.Stderr, "# changed %f %f %d %d\n", p.x, p.y, oldLabel, labels[i])
+			changed++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "# %d removed, %d added, %d iterations, %d of %d kept points changed cluster\n",
+		removed, added, iterations, changed, len(points)-added)
+
+	for i, centroid := range model.Centroids {
+		raw := model.unscale(centroid)
+		fmt.Printf("%f %f c%d\n", raw[0], raw[1], i)
+	}
+	for c := range model.Centroids {
+		for i, p := range points {
+			if labels[i] == c {
+				fmt.Printf("%f %f %d\n", p.x, p.y, c)
+			}
+		}
+	}
+
+	if *pointsFile != "" {
+		writePoints(*pointsFile, points)
+		filename = *pointsFile
+	}
+
+	if *saveFile != "" {
+		model.Training.File = filename
+		model.Training.Points = len(points)
+		model.Training.Iterations = iterations
+		model.Training.Trained = time.Now().UTC()
+		model.Training.Sizes = make([]int, len(model.Centroids))
+		model.Training.SSE = 0
+		for i, p := range points {
+			model.Training.Sizes[labels[i]]++
+			model.Training.SSE += sqDistance(model.scale(p), model.Centroids[labels[i]])
+		}
+		writeModel(*saveFile, model)
+	}
+}
+
+/*
+Lloyd's algorithm, starting from the model's centroids and updating
+them in place. A cluster that loses all its points keeps its old
+centroid, so it keeps its label too. Like km1, centroids count as
+settled once they stop moving in original units, whatever the scaling.
+*/
+func (m *Model) lloyd(points []Point) ([]int, int) {
+	scaled := make([][]float64, len(points))
+	for i, p := range points {
+		scaled[i] = m.scale(p)
+	}
+
+	k := len(m.Centroids)
+	labels := make([]int, len(points))
+	iterations := 0
+
+	for looping := true; looping; {
+		iterations++
+		for i, v := range scaled {
+			labels[i] = m.nearest(v)
+		}
+
+		sums := make([][]float64, k)
+		counts := make([]float64, k)
+		for c := range sums {
+			sums[c] = make([]float64, m.Dimension)
+		}
+		for i, v := range scaled {
+			counts[labels[i]]++
+			for j := range v {
+				sums[labels[i]][j] += v[j]
+			}
+		}
+
+		looping = false
+		for c := range sums {
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range sums[c] {
+				sums[c][j] /= counts[c]
+			}
+			if sqDistance(m.unscale(sums[c]), m.unscale(m.Centroids[c])) > 0.01 {
+				looping = true // keep looping
+			}
+			m.Centroids[c] = sums[c]
+		}
+	}
+
+	return labels, iterations
+}
+
+func (m *Model) nearest(v []float64) int {
+	min := math.Inf(1)
+	cent := 0
+	for i, centroid := range m.Centroids {
+		if d := sqDistance(v, centroid); d < min {
+			min = d
+			cent = i
+		}
+	}
+	return cent
+}
+
+func sqDistance(a, b []float64) float64 {
+	d := 0.0
+	for j := range a {
+		d += (a[j] - b[j]) * (a[j] - b[j])
+	}
+	return d
+}
+
+func (m *Model) scale(p Point) []float64 {
+	v := []float64{p.x, p.y}
+	for j := range v {
+		v[j] = (v[j] - m.Scaling.Center[j]) / m.Scaling.Scale[j]
+	}
+	return v
+}
+
+func (m *Model) unscale(v []float64) []float64 {
+	raw := make([]float64, len(v))
+	for j := range v {
+		raw[j] = v[j]*m.Scaling.Scale[j] + m.Scaling.Center[j]
+	}
+	return raw
+}
+
+func readModel(filename string) *Model {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	model := &Model{}
+	if err := json.Unmarshal(buf, model); err != nil {
+		log.Fatalf("model %s: %v\n", filename, err)
+	}
+
+	if model.Dimension != 2 {
+		log.Fatalf("model %s: dimension %d, only 2 supported\n", filename, model.Dimension)
+	}
+	if model.Metric != "euclidean" {
+		log.Fatalf("model %s: unknown metric %q\n", filename, model.Metric)
+	}
+	if len(model.Scaling.Center) != model.Dimension || len(model.Scaling.Scale) != model.Dimension {
+		log.Fatalf("model %s: scaling doesn't match dimension %d\n", filename, model.Dimension)
+	}
+	for j, scale := range model.Scaling.Scale {
+		center := model.Scaling.Center[j]
+		if scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) || math.IsNaN(center) || math.IsInf(center, 0) {
+			log.Fatalf("model %s: bad scaling for dimension %d, center %g scale %g\n", filename, j, center, scale)
+		}
+	}
+	if len(model.Centroids) == 0 {
+		log.Fatalf("model %s: no centroids\n", filename)
+	}
+	for i, centroid := range model.Centroids {
+		if len(centroid) != model.Dimension {
+			log.Fatalf("model %s: centroid %d has %d coordinates, want %d\n",
+				filename, i, len(centroid), model.Dimension)
+		}
+	}
+
+	return model
+}
+
+func writeModel(filename string, model *Model) {
+	buf, err := json.MarshalIndent(model, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	buf = append(buf, '\n')
+	if err := os.WriteFile(filename, buf, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Points one "x y" line each, in full precision so that
+// re-reading them gets exactly the points clustered.
+func writePoints(filename string, points []Point) {
+	fout, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(fout)
+	for _, p := range points {
+		fmt.Fprintf(w, "%g %g\n", p.x, p.y)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := fout.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
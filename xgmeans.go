@@ -0,0 +1,501 @@
+package main
+
+/*
+   K-means clustering that grows k on its own, instead of taking k
+   on the command line like km1 does.
+
+   Usage: xgmeans [-mode xmeans|gmeans] [-kmin N] [-kmax N] filename
+
+   X-means (Pelleg & Moore) tries splitting every centroid in two,
+   and keeps the split if the Bayesian Information Criterion of the
+   two child clusters beats the BIC of the parent cluster.
+
+   G-means (Hamerly & Elkan) tries splitting every centroid in two,
+   projects the cluster's points onto the line joining the two children,
+   and keeps the split if an Anderson-Darling test says the projection
+   isn't normally distributed.
+
+   Either way, after each round of splits, kmeanscluster re-runs Lloyd's
+   algorithm starting from the grown set of centroids. Split decisions
+   and the final k go to stderr as "# ..." lines, the centroids and
+   labeled points go to stdout in the same format km1 uses.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// Point an x,y cartesian point
+type Point struct {
+	x float64
+	y float64
+}
+
+type dist struct {
+	D2         float64
+	pointIndex int
+}
+
+// Critical value of the Anderson-Darling statistic at
+// significance level 0.0001, the value the G-means paper uses.
+const adCritical = 1.8692
+
+func main() {
+	mode := flag.String("mode", "xmeans", "k-growing mode, xmeans or gmeans")
+	kmin := flag.Int("kmin", 1, "starting number of clusters")
+	kmax := flag.Int("kmax", 50, "maximum number of clusters")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: xgmeans [-mode xmeans|gmeans] [-kmin N] [-kmax N] filename")
+	}
+
+	var splitter func([]Point, []Point) ([]Point, bool)
+	switch *mode {
+	case "xmeans":
+		splitter = bicSplit
+	case "gmeans":
+		splitter = andersonDarlingSplit
+	default:
+		log.Fatalf("unknown mode %q, want xmeans or gmeans\n", *mode)
+	}
+
+	points := readPoints(flag.Arg(0))
+	if *kmin < 1 || *kmin > len(points) {
+		log.Fatalf("kmin %d out of range for %d points\n", *kmin, len(points))
+	}
+
+	ps := PointSlice(points)
+	sort.Sort(ps)
+
+	rand.Seed(time.Now().UnixNano() + int64(os.Getpid()))
+
+	centroids, clusters := kmeanscluster(kMeansPPCentroids(*kmin, points), points)
+
+	for {
+		var grown []Point
+		splits := 0
+		for i := range clusters {
+			if len(grown)+len(clusters)-i >= *kmax {
+				// No room for more centroids, keep the rest as is.
+				grown = append(grown, centroids[i:]...)
+				break
+			}
+			fmt.Fprintf(os.Stderr, "# k = %d, cluster %d, %d points: ", len(centroids), i, len(clusters[i]))
+			children, split := splitter(clusters[i], []Point{centroids[i]})
+			if split {
+				grown = append(grown, children...)
+				splits++
+			} else {
+				grown = append(grown, centroids[i])
+			}
+		}
+
+		if splits == 0 {
+			break
+		}
+
+		centroids, clusters = kmeanscluster(grown, points)
+	}
+
+	fmt.Fprintf(os.Stderr, "# %s chose k = %d\n", *mode, len(centroids))
+
+	for i, centroid := range centroids {
+		fmt.Printf("%f %f c%d\n", centroid.x, centroid.y, i)
+	}
+
+	for i, cluster := range clusters {
+		for _, point := range cluster {
+			fmt.Printf("%f %f %d\n", point.x, point.y, i)
+		}
+	}
+}
+
+/*
+Lloyd's algorithm, starting from the centroids passed in.
+Returns the converged centroids and the points in each cluster.
+*/
+func kmeanscluster(centroids []Point, points []Point) ([]Point, [][]Point) {
+
+	k := len(centroids)
+
+	looping := true
+
+	var finalclusters [][]Point
+
+	for looping {
+
+		clusters := make([][]Point, k)
+
+		for _, point := range points {
+			cent := nearestCentroid(point, centroids)
+			clusters[cent] = append(clusters[cent], point)
+		}
+
+		newcentroids := calcCentroids(clusters, centroids)
+		looping = compareCentroids(centroids, newcentroids)
+		centroids = newcentroids
+		finalclusters = clusters
+	}
+
+	return centroids, finalclusters
+}
+
+func nearestCentroid(point Point, centroids []Point) int {
+	min := math.Inf(1)
+	cent := 0
+	for i, centroid := range centroids {
+		dx := centroid.x - point.x
+		dy := centroid.y - point.y
+		if d := dx*dx + dy*dy; d < min {
+			min = d
+			cent = i
+		}
+	}
+	return cent
+}
+
+/*
+Split a cluster in two with 2-means on its own points.
+Returns false for clusters too small to split, or when
+both children end up in the same place.
+*/
+func splitCluster(cluster []Point) ([]Point, [][]Point, bool) {
+	if len(cluster) < 4 {
+		return nil, nil, false
+	}
+	children, parts := kmeanscluster(kMeansPPCentroids(2, cluster), cluster)
+	if len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, nil, false
+	}
+	return children, parts, true
+}
+
+/*
+X-means split test: split the cluster if the children's BIC
+is bigger than the parent's BIC.
+*/
+func bicSplit(cluster []Point, parent []Point) ([]Point, bool) {
+	children, parts, ok := splitCluster(cluster)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "can't split, keep\n")
+		return nil, false
+	}
+
+	parentBIC := bic([][]Point{cluster}, parent)
+	childBIC := bic(parts, children)
+
+	split := childBIC > parentBIC
+	fmt.Fprintf(os.Stderr, "BIC parent %f children %f, %s\n", parentBIC, childBIC, verdict(split))
+
+	return children, split
+}
+
+/*
+Bayesian Information Criterion of a clustering, assuming identical
+spherical Gaussians around each centroid, as in the X-means paper.
+*/
+func bic(clusters [][]Point, centroids []Point) float64 {
+	const d = 2.0
+	K := float64(len(centroids))
+
+	R := 0.0
+	sse := 0.0
+	for i, cluster := range clusters {
+		R += float64(len(cluster))
+		for _, point := range cluster {
+			dx := point.x - centroids[i].x
+			dy := point.y - centroids[i].y
+			sse += dx*dx + dy*dy
+		}
+	}
+
+	if R <= K {
+		return math.Inf(-1)
+	}
+	variance := sse / (d * (R - K))
+	if variance <= 0 {
+		variance = math.SmallestNonzeroFloat64
+	}
+
+	loglikelihood := 0.0
+	for _, cluster := range clusters {
+		Rn := float64(len(cluster))
+		if Rn == 0 {
+			continue
+		}
+		loglikelihood += Rn*math.Log(Rn) - Rn*math.Log(R) -
+			Rn/2*math.Log(2*math.Pi) - Rn*d/2*math.Log(variance) - (Rn-K)/2
+	}
+
+	// K-1 mixing weights, d*K centroid coordinates, 1 variance
+	params := (K - 1) + d*K + 1
+
+	return loglikelihood - params/2*math.Log(R)
+}
+
+/*
+G-means split test: project the cluster onto the vector between
+its two children, standardize, and split if the Anderson-Darling
+statistic rejects normality.
+*/
+func andersonDarlingSplit(cluster []Point, parent []Point) ([]Point, bool) {
+	children, _, ok := splitCluster(cluster)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "can't split, keep\n")
+		return nil, false
+	}
+
+	vx := children[0].x - children[1].x
+	vy := children[0].y - children[1].y
+	norm := vx*vx + vy*vy
+
+	projected := make([]float64, len(cluster))
+	for i, point := range cluster {
+		projected[i] = (point.x*vx + point.y*vy) / norm
+	}
+
+	a2 := andersonDarling(projected)
+	split := a2 > adCritical
+	fmt.Fprintf(os.Stderr, "A*^2 %f critical %f, %s\n", a2, adCritical, verdict(split))
+
+	return children, split
+}
+
+/*
+Anderson-Darling statistic of a sample against the normal distribution,
+with mean and variance estimated from the sample, including the
+small-sample correction A*^2 = A^2(1 + 4/n - 25/n^2).
+*/
+func andersonDarling(x []float64) float64 {
+	n := float64(len(x))
+
+	mean := 0.0
+	for _, v := range x {
+		mean += v
+	}
+	mean /= n
+
+	variance := 0.0
+	for _, v := range x {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= n - 1
+	sd := math.Sqrt(variance)
+	if sd == 0 {
+		return 0
+	}
+
+	z := make([]float64, len(x))
+	for i, v := range x {
+		z[i] = (v - mean) / sd
+	}
+	sort.Float64s(z)
+
+	sum := 0.0
+	for i := range z {
+		lo := clampProb(normalCDF(z[i]))
+		hi := clampProb(normalCDF(z[len(z)-1-i]))
+		sum += float64(2*i+1) * (math.Log(lo) + math.Log(1-hi))
+	}
+	a2 := -n - sum/n
+
+	return a2 * (1 + 4/n - 25/(n*n))
+}
+
+func normalCDF(z float64) float64 {
+	return 0.5 * math.Erfc(-z/math.Sqrt2)
+}
+
+// Keep probabilities away from 0 and 1, so the logarithms stay finite.
+func clampProb(p float64) float64 {
+	const eps = 1e-15
+	if p < eps {
+		return eps
+	}
+	if p > 1-eps {
+		return 1 - eps
+	}
+	return p
+}
+
+func verdict(split bool) string {
+	if split {
+		return "split"
+	}
+	return "keep"
+}
+
+func compareCentroids(centroids []Point, newcentroids []Point) bool {
+	if len(centroids) != len(newcentroids) {
+		log.Fatalf("%d old centroids, %d newcentroids\n", len(centroids), len(newcentroids))
+	}
+
+	for i := 0; i < len(centroids); i++ {
+		dx := centroids[i].x - newcentroids[i].x
+		dy := centroids[i].y - newcentroids[i].y
+		dist := dx*dx + dy*dy
+		if dist > 0.01 {
+			return true // keep looping
+		}
+	}
+
+	return false // stop looping
+}
+
+type PointSlice []Point
+
+func (ps PointSlice) Len() int { return len(ps) }
+func (ps PointSlice) Less(i, j int) bool {
+	if ps[i].x < ps[j].x {
+		return true
+	}
+	if ps[i].x == ps[j].x {
+		return ps[i].y < ps[j].y
+	}
+	return false
+}
+func (ps PointSlice) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+
+/*
+An empty cluster keeps its old centroid, rather than
+getting a NaN centroid from dividing by zero.
+*/
+func calcCentroids(clusters [][]Point, old []Point) []Point {
+	centroids := make([]Point, len(clusters))
+
+	for cent, cluster := range clusters {
+		if len(cluster) == 0 {
+			centroids[cent] = old[cent]
+			continue
+		}
+		var sumx, sumy float64
+		for _, point := range cluster {
+			sumx += point.x
+			sumy += point.y
+		}
+		centroids[cent] = Point{x: sumx / float64(len(cluster)), y: sumy / float64(len(cluster))}
+	}
+
+	return centroids
+}
+
+func readPoints(filename string) []Point {
+	fin, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fin.Close()
+
+	var points []Point
+
+	for {
+		var p Point
+		n, err := fmt.Fscanf(fin, "%f %f\n", &p.x, &p.y)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Print(err)
+			continue
+		}
+		if n != 2 {
+			log.Printf("Parsed %d items, wanted 2\n", n)
+			continue
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
+
+/*
+		k-means++ method of finding initial guesses at centroids.
+
+	 1. Choose one center uniformly at random among the data points.
+	 2. For each data point x, compute D(x), the distance between x and the nearest
+	    center that has already been chosen.
+	 3. Choose one new data point at random as a new center, using a weighted
+	    probability distribution where a point x is chosen with probability
+	    proportional to D(x)^2.
+	 4. Repeat Steps 2 and 3 until k centers have been chosen.
+*/
+func kMeansPPCentroids(k int, points []Point) (centroids []Point) {
+
+	centroids = append(centroids, points[rand.Intn(len(points))])
+
+	D := make([]dist, len(points))
+
+	for i := 0; i < k-1; i++ {
+		fillDistances(D, points, centroids)
+		newCenterIndex := weightedChoice(D)
+		centroids = append(centroids, points[newCenterIndex])
+	}
+
+	return
+}
+
+type interval struct {
+	maxval float64
+	index  int
+}
+
+/*
+Choose one new data point at random as a new center, using a weighted
+probability distribution where a point x is chosen with probability
+proportional to D(x)^2.
+*/
+func weightedChoice(D []dist) int {
+	sumValues := 0.0
+
+	var intervals []interval
+
+	for i := range D {
+		sumValues += D[i].D2
+		intervals = append(intervals, interval{maxval: sumValues, index: D[i].pointIndex})
+	}
+
+	inInterval := rand.Float64() * sumValues
+
+	for i := range intervals {
+		if inInterval < intervals[i].maxval {
+			return intervals[i].index
+		}
+	}
+
+	return 0
+}
+
+/*
+For each data point x, compute D(x)^2, the squared distance between x
+and the nearest center that has already been chosen.
+*/
+func fillDistances(D []dist, points []Point, centroids []Point) {
+
+	for idx, point := range points {
+		dx := point.x - centroids[0].x
+		dy := point.y - centroids[0].y
+		minD := dx*dx + dy*dy
+
+		for _, center := range centroids {
+			dx := point.x - center.x
+			dy := point.y - center.y
+			d := dx*dx + dy*dy
+			if d < minD {
+				minD = d
+			}
+		}
+
+		D[idx].D2 = minD
+		D[idx].pointIndex = idx
+	}
+}